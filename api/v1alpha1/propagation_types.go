@@ -0,0 +1,84 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types of a propagation
+const (
+	// The propagation converged on the target cluster
+	ConditionReady = "Ready"
+	// All objects of the propagation were written to the target cluster
+	ConditionSynced = "Synced"
+	// Objects on the target cluster are owned by another origin
+	ConditionConflict = "Conflict"
+	// The propagation did not become ready within the readiness timeout
+	ConditionDegraded = "Degraded"
+	// The propagation failed permanently, it's retried once the origin ingress changes
+	ConditionTerminal = "Terminal"
+)
+
+// PropagationSpec references the origin ingress
+type PropagationSpec struct {
+	// Name of the origin ingress in the same namespace
+	Ingress string `json:"ingress"`
+}
+
+// PropagatedObject references an object on the target cluster
+type PropagatedObject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// PropagationStatus is the observed state of a propagation
+type PropagationStatus struct {
+	// Identifier of the propagator
+	Identifier string `json:"identifier,omitempty"`
+	// Name of the ingress on the target cluster
+	TargetName string `json:"targetName,omitempty"`
+	// Namespace on the target cluster
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+	// Objects created on the target cluster
+	Objects []PropagatedObject `json:"objects,omitempty"`
+	// Backend addresses propagated to the target cluster
+	Addresses []string `json:"addresses,omitempty"`
+	// Last successful sync to the target cluster
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Generation of the origin ingress last observed
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=prop
+// +kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.status.targetName`
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.status.targetNamespace`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Propagation is the state of an ingress propagated to the target cluster
+type Propagation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PropagationSpec   `json:"spec,omitempty"`
+	Status PropagationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PropagationList contains a list of Propagation
+type PropagationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Propagation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Propagation{}, &PropagationList{})
+}
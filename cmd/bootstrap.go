@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/kinds"
+	"github.com/spf13/cobra"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Key of the kubeconfig in secrets, the default of the helm chart
+const kubeconfigSecretKey = "kubeconfig.yaml"
+
+// Labels of objects created by bootstrap-target, they aren't managed by an identifier
+var bootstrapLabels = map[string]string{"app.kubernetes.io/managed-by": "svc-ingress-propagator"}
+
+// bootstrapObject is created or updated on the target cluster, mutate sets the desired state
+type bootstrapObject struct {
+	obj    client.Object
+	mutate func()
+}
+
+type bootstrapFlags struct {
+	adminKubeconfig string
+	serviceAccount  string
+	server          string
+	tokenDuration   time.Duration
+	outputFile      string
+	outputSecret    string
+}
+
+func bootstrapCommand(options *rootCmdFlags) *cobra.Command {
+	flags := bootstrapFlags{
+		serviceAccount: "svc-ingress-propagator",
+		outputFile:     "-",
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bootstrap-target",
+		Short: "Create the target namespace, service account and RBAC and write a kubeconfig for the propagator",
+		Long: "Connects to the target cluster with admin credentials and creates the target namespace, a service account " +
+			"with the permissions required by the configured options and a token. The kubeconfig of the service account " +
+			"is written to a file, stdout or a secret on the source cluster. Existing objects are updated.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flags.adminKubeconfig == "" {
+				return fmt.Errorf("--admin-kubeconfig is required")
+			}
+			controllerOptions, err := options.controllerOptions()
+			if err != nil {
+				return err
+			}
+
+			admin, err := clientcmd.BuildConfigFromFlags("", flags.adminKubeconfig)
+			if err != nil {
+				return err
+			}
+			c, err := client.New(admin, client.Options{Scheme: scheme})
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			token, err := bootstrapTarget(ctx, c, cmd.ErrOrStderr(), flags, controllerOptions)
+			if err != nil {
+				return err
+			}
+
+			kubeconfig, err := targetKubeconfig(admin, flags.server, options.targetNamespace, flags.serviceAccount, token)
+			if err != nil {
+				return err
+			}
+			return writeKubeconfig(ctx, options, cmd.OutOrStdout(), cmd.ErrOrStderr(), flags, kubeconfig)
+		},
+	}
+
+	cmd.Flags().StringVar(&flags.adminKubeconfig, "admin-kubeconfig", flags.adminKubeconfig, "kubeconfig with admin credentials of the target cluster")
+	cmd.Flags().StringVar(&flags.serviceAccount, "service-account", flags.serviceAccount, "name of the service account and RBAC objects on the target cluster")
+	cmd.Flags().StringVar(&flags.server, "server", flags.server, "target api server url written to the kubeconfig (Defaults to the server of the admin kubeconfig)")
+	cmd.Flags().DurationVar(&flags.tokenDuration, "token-duration", flags.tokenDuration, "lifetime of a bound service account token, a long-lived token secret is created if unset")
+	cmd.Flags().StringVarP(&flags.outputFile, "output-file", "o", flags.outputFile, "file the kubeconfig is written to, - writes to stdout")
+	cmd.Flags().StringVar(&flags.outputSecret, "output-secret", flags.outputSecret, "secret (namespace/name) on the source cluster the kubeconfig is written to, instead of a file")
+	return cmd
+}
+
+// bootstrapTarget creates the namespace, service account and RBAC on the target cluster and returns a token
+func bootstrapTarget(ctx context.Context, c client.Client, log io.Writer, flags bootstrapFlags, options controller.PropagationControllerOptions) (string, error) {
+	name := flags.serviceAccount
+	namespace := options.TargetNamespace
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}}
+
+	var rules, clusterRules []rbacv1.PolicyRule
+	for _, permission := range controller.RequiredTargetPermissions(options) {
+		rule := rbacv1.PolicyRule{
+			APIGroups:     []string{permission.Group},
+			Resources:     []string{permission.Resource},
+			Verbs:         permission.Verbs,
+			ResourceNames: permission.ResourceNames,
+		}
+		if permission.ClusterScoped {
+			clusterRules = append(clusterRules, rule)
+		} else {
+			rules = append(rules, rule)
+		}
+	}
+	// Cluster scoped objects are shared by all target namespaces
+	clusterName := fmt.Sprintf("%s-%s", name, namespace)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	binding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: clusterName}}
+	clusterBinding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: clusterName}}
+	objects := []bootstrapObject{
+		{obj: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}},
+		{obj: sa},
+		{obj: role, mutate: func() { role.Rules = rules }},
+		{obj: binding, mutate: func() {
+			binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name}
+			binding.Subjects = subjects
+		}},
+		{obj: clusterRole, mutate: func() { clusterRole.Rules = clusterRules }},
+		{obj: clusterBinding, mutate: func() {
+			clusterBinding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterName}
+			clusterBinding.Subjects = subjects
+		}},
+	}
+
+	for _, o := range objects {
+		obj := o.obj
+		result, err := controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			for k, v := range bootstrapLabels {
+				labels[k] = v
+			}
+			obj.SetLabels(labels)
+			if o.mutate != nil {
+				o.mutate()
+			}
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s %s: %s", kinds.Of(scheme, obj), obj.GetName(), err)
+		}
+		fmt.Fprintf(log, "%s %s %s\n", kinds.Of(scheme, obj), obj.GetName(), result)
+	}
+
+	if flags.tokenDuration > 0 {
+		seconds := int64(flags.tokenDuration.Seconds())
+		request := &authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds}}
+		if err := c.SubResource("token").Create(ctx, sa, request); err != nil {
+			return "", fmt.Errorf("failed to request token of service account %s: %s", name, err)
+		}
+		return request.Status.Token, nil
+	}
+	return longLivedToken(ctx, c, log, sa)
+}
+
+// longLivedToken creates a service account token secret and waits until the token is populated
+func longLivedToken(ctx context.Context, c client.Client, log io.Writer, sa *corev1.ServiceAccount) (string, error) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: sa.Name, Namespace: sa.Namespace}}
+	result, err := controllerutil.CreateOrUpdate(ctx, c, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		for k, v := range bootstrapLabels {
+			secret.Labels[k] = v
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[corev1.ServiceAccountNameKey] = sa.Name
+		secret.Type = corev1.SecretTypeServiceAccountToken
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create token secret %s: %s", secret.Name, err)
+	}
+	fmt.Fprintf(log, "Secret %s %s\n", secret.Name, result)
+
+	var token string
+	err = wait.PollUntilContextTimeout(ctx, time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+			return false, err
+		}
+		token = string(secret.Data[corev1.ServiceAccountTokenKey])
+		return token != "", nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("token of secret %s was not populated: %s", secret.Name, err)
+	}
+	return token, nil
+}
+
+// targetKubeconfig returns a kubeconfig of the service account, using the server and ca of the admin config
+func targetKubeconfig(admin *rest.Config, server string, namespace string, serviceAccount string, token string) ([]byte, error) {
+	if server == "" {
+		server = admin.Host
+	}
+	cluster := &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: admin.CAData,
+		InsecureSkipTLSVerify:    admin.Insecure,
+	}
+	if len(cluster.CertificateAuthorityData) == 0 && admin.CAFile != "" {
+		ca, err := os.ReadFile(admin.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cluster.CertificateAuthorityData = ca
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters["target"] = cluster
+	config.AuthInfos[serviceAccount] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts["target"] = &clientcmdapi.Context{Cluster: "target", AuthInfo: serviceAccount, Namespace: namespace}
+	config.CurrentContext = "target"
+	return clientcmd.Write(*config)
+}
+
+// writeKubeconfig writes the kubeconfig to a secret on the source cluster, a file or stdout
+func writeKubeconfig(ctx context.Context, options *rootCmdFlags, out io.Writer, log io.Writer, flags bootstrapFlags, kubeconfig []byte) error {
+	if flags.outputSecret != "" {
+		key, err := namespacedName(flags.outputSecret)
+		if err != nil {
+			return fmt.Errorf("invalid output secret: %s", err)
+		}
+		config, err := options.sourceConfig()
+		if err != nil {
+			return err
+		}
+		source, err := client.New(config, client.Options{Scheme: scheme})
+		if err != nil {
+			return err
+		}
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		result, err := controllerutil.CreateOrUpdate(ctx, source, secret, func() error {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[kubeconfigSecretKey] = kubeconfig
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write kubeconfig to secret %s: %s", flags.outputSecret, err)
+		}
+		fmt.Fprintf(log, "source Secret %s %s\n", flags.outputSecret, result)
+		return nil
+	}
+
+	if flags.outputFile == "-" {
+		_, err := out.Write(kubeconfig)
+		return err
+	}
+	if err := os.WriteFile(flags.outputFile, kubeconfig, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "kubeconfig written to %s\n", flags.outputFile)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sourceConfig returns the config of the source cluster, from --kubeconfig or the default loading rules
+func (o *rootCmdFlags) sourceConfig() (*rest.Config, error) {
+	if o.kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", o.kubeconfig)
+	}
+	return ctrl.GetConfig()
+}
+
+// propagator returns a propagation controller with direct clients to both clusters, for use outside the manager
+func (o *rootCmdFlags) propagator() (*controller.PropagationController, error) {
+	controllerOptions, err := o.controllerOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	sourceConfig, err := o.sourceConfig()
+	if err != nil {
+		return nil, err
+	}
+	source, err := client.New(sourceConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	targetConfig, err := clientcmd.BuildConfigFromFlags("", o.targetKubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	target, err := client.NewWithWatch(targetConfig, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &controller.PropagationController{
+		Client:       source,
+		TargetClient: target,
+		Log:          o.logger,
+		Recorder:     &record.FakeRecorder{},
+		Options:      controllerOptions,
+	}, nil
+}
+
+// controlledPropagation is the propagation of a controlled ingress, err is set if it can't be propagated
+type controlledPropagation struct {
+	propagation.Propagation
+	err error
+}
+
+// controlledPropagations returns the propagations of all ingresses controlled by the propagator, sorted by origin
+func controlledPropagations(ctx context.Context, propagator *controller.PropagationController) ([]controlledPropagation, error) {
+	ingresses := networkingv1.IngressList{}
+	if err := propagator.Client.List(ctx, &ingresses); err != nil {
+		return nil, err
+	}
+	sort.Slice(ingresses.Items, func(a, b int) bool {
+		return client.ObjectKeyFromObject(&ingresses.Items[a]).String() < client.ObjectKeyFromObject(&ingresses.Items[b]).String()
+	})
+
+	var props []controlledPropagation
+	for _, ingress := range ingresses.Items {
+		controlled, err := propagator.IsControlled(ctx, ingress)
+		if err != nil {
+			return nil, err
+		}
+		if !controlled {
+			continue
+		}
+		prop, err := propagator.FromIngressToPropagation(ctx, propagator.Client, ingress)
+		props = append(props, controlledPropagation{Propagation: prop, err: err})
+	}
+	return props, nil
+}
+
+// propagationsOf returns the plain propagations
+func propagationsOf(controlled []controlledPropagation) []propagation.Propagation {
+	props := make([]propagation.Propagation, 0, len(controlled))
+	for _, c := range controlled {
+		props = append(props, c.Propagation)
+	}
+	return props
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+// Drift of target objects by change action
+var driftOf = map[string]string{
+	diff.ActionCreate: "missing",
+	diff.ActionUpdate: "changed",
+	diff.ActionDelete: "extra",
+}
+
+func diffCommand(options *rootCmdFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the desired propagations with the objects in the target namespace",
+		Long: "Computes the propagation of every controlled ingress of the source cluster and prints the objects " +
+			"missing, changed or extra in the target namespace. Exits non-zero on drift.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			propagator, err := options.propagator()
+			if err != nil {
+				return err
+			}
+
+			props, err := controlledPropagations(ctx, propagator)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			drift := 0
+			for _, prop := range props {
+				origin := fmt.Sprintf("%s/%s", prop.Origin.Namespace, prop.Origin.Name)
+				if prop.err != nil {
+					fmt.Fprintf(out, "%s: can't be propagated: %s\n", origin, prop.err)
+					drift++
+					continue
+				}
+
+				var changes []diff.Change
+				if prop.IsDeleted {
+					changes, err = propagator.DiffRemoval(ctx, prop.Propagation)
+				} else {
+					changes, err = propagator.Diff(ctx, prop.Propagation)
+				}
+				if err != nil {
+					return fmt.Errorf("diff %s: %s", origin, err)
+				}
+				printDrift(out, origin, changes)
+				drift += len(changes)
+			}
+
+			orphans, err := propagator.Orphans(ctx, propagationsOf(props))
+			if err != nil {
+				return err
+			}
+			printDrift(out, "no origin", orphans)
+			drift += len(orphans)
+
+			if drift > 0 {
+				return fmt.Errorf("target namespace %s drifted: %d differences", options.targetNamespace, drift)
+			}
+			fmt.Fprintf(out, "target namespace %s is in sync\n", options.targetNamespace)
+			return nil
+		},
+	}
+}
+
+func printDrift(out io.Writer, origin string, changes []diff.Change) {
+	for _, change := range changes {
+		fmt.Fprintf(out, "%s: %s %s %s\n", origin, driftOf[change.Action], change.Kind, change.Name)
+		for _, field := range change.Fields {
+			fmt.Fprintf(out, "    %s\n", field)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/spf13/cobra"
+)
+
+func doctorCommand(options *rootCmdFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Run the preflight checks of the target cluster",
+		Long: "Verifies the target kubeconfig grants every verb required by the configured options and the target " +
+			"namespace, ingress class, issuer and certificate resource exist. Exits non-zero if a check fails, the " +
+			"controller refuses to start in that case.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			propagator, err := options.propagator()
+			if err != nil {
+				return err
+			}
+
+			results := propagator.Preflight(cmd.Context())
+			if err := printChecks(cmd.OutOrStdout(), results); err != nil {
+				return err
+			}
+			if controller.PreflightFailed(results) {
+				return fmt.Errorf("preflight checks failed")
+			}
+			return nil
+		},
+	}
+}
+
+func printChecks(out io.Writer, results []controller.CheckResult) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tMESSAGE")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Name, result.Status, result.Message)
+	}
+	return w.Flush()
+}
@@ -1,145 +1,158 @@
 package main
 
 import (
-	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
 	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/health"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/logging"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/tracing"
 	"github.com/go-logr/logr"
-	"github.com/go-logr/stdr"
 	"github.com/spf13/cobra"
 	_ "go.uber.org/automaxprocs"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	crlog "sigs.k8s.io/controller-runtime/pkg/log"
-	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 )
 
 type rootCmdFlags struct {
 	controllerClass string
+	logging         logging.Options
 	logger          logr.Logger
 	// for annotation on Ingress
 	ingressClass string
 	// for identifying objects on parent cluster
 	identifier string
-	// Binary log level
-	logLevel int
 	// Ingress class on loadbalancer cluster
 	targetIngressClass     string
 	targetNamespace        string
 	targetKubeconfig       string
+	kubeconfig             string
 	targetIssuerNamespaced bool
 	targetIssuerName       string
 	metricsAddr            string
 	enableLeaderElection   bool
 	tlsRepsect             bool
+	targetIPFamilies       []string
+	nodePortFallback       bool
+	nodeSelector           string
+	nodeAddressType        string
+	probe                  prober.Options
+	certificateSync        bool
+	certificateSyncSuffix  string
+	certificateMode        string
+	issuerKind             string
+	issuerGroup            string
+	certificateDuration    time.Duration
+	certificateKeyAlg      string
+	certificateDNSNames    []string
+	tlsStrategy            string
+	waitForReadiness       bool
+	readinessTimeout       time.Duration
+	readinessInterval      time.Duration
+	propagationStatus      bool
+	tracing                tracing.Options
+	allowedDomains         []string
+	webhook                bool
+	webhookMode            string
+	webhookPort            int
+	webhookCertDir         string
+	dryRun                 bool
+	preflight              bool
+	targetHealth           health.Options
+	backoffBase            time.Duration
+	backoffMax             time.Duration
+	deletionTimeout        time.Duration
+	pendingDeletions       string
+	deletionPolicy         string
 }
 
 var (
+	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
 )
 
-func main() {
-	var rootLogger = stdr.NewWithOptions(log.New(os.Stderr, "", log.LstdFlags), stdr.Options{LogCaller: stdr.All})
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
 
+func main() {
 	options := rootCmdFlags{
-		logger:             rootLogger.WithName("main"),
 		ingressClass:       "propagator",
 		targetIngressClass: "propagator",
 		targetNamespace:    "propagator",
 		controllerClass:    "buttah.cloud/svc-ingress-propagator",
-		logLevel:           0,
+		logging: logging.Options{
+			Format:   logging.FormatText,
+			Sampling: true,
+		},
+		targetIPFamilies:      []string{string(corev1.IPv4Protocol), string(corev1.IPv6Protocol)},
+		nodeAddressType:       string(corev1.NodeInternalIP),
+		certificateSyncSuffix: "-propagated-tls",
+		certificateMode:       controller.CertificateModeAnnotation,
+		tlsStrategy:           controller.TLSStrategyCombined,
+		readinessTimeout:      10 * time.Minute,
+		readinessInterval:     15 * time.Second,
+		backoffBase:           time.Second,
+		backoffMax:            5 * time.Minute,
+		deletionPolicy:        controller.DeletionPolicyDelete,
+		webhookMode:           controller.WebhookModeDeny,
+		webhookPort:           9443,
+		tracing: tracing.Options{
+			SampleRatio: 1,
+			ServiceName: "svc-ingress-propagator",
+		},
+		targetHealth: health.Options{
+			Interval:         30 * time.Second,
+			Timeout:          10 * time.Second,
+			FailureThreshold: 3,
+		},
+		probe: prober.Options{
+			Path:             "/",
+			Interval:         10 * time.Second,
+			Timeout:          3 * time.Second,
+			SuccessThreshold: 1,
+			FailureThreshold: 3,
+		},
 	}
 
-	crlog.SetLogger(rootLogger.WithName("controller-runtime"))
-
 	rootCommand := cobra.Command{
-		Use: "tunnel-controller",
+		Use:          "tunnel-controller",
+		Short:        "Propagates ingresses and their backends to a target cluster",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return options.setupLogging()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stdr.SetVerbosity(options.logLevel)
-			logger := options.logger
-			logger.Info("logging verbosity", "level", options.logLevel)
-
-			// Load the kubeconfig from the provided file path
-			target, err := clientcmd.BuildConfigFromFlags("", options.targetKubeconfig)
-			if err != nil {
-				logger.Error(err, "unable to load target kubeconfig")
-				os.Exit(1)
-			}
-			targetClient, err := client.New(target, client.Options{})
-			if err != nil {
-				logger.Error(err, "unable to set up target client")
-				os.Exit(1)
-			}
-			if options.targetNamespace == "" {
-				logger.Error(fmt.Errorf("target namespace must be defined"), "")
-				os.Exit(1)
-			}
-
-			manager, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-				Metrics: metricsserver.Options{
-					BindAddress: options.metricsAddr,
-				},
-				LeaderElection:         options.enableLeaderElection,
-				LeaderElectionID:       "2c123jea.buttah.cloud",
-				HealthProbeBindAddress: ":10080",
-				NewClient: func(config *rest.Config, options client.Options) (client.Client, error) {
-					options.Cache.Unstructured = true
-					return client.New(config, options)
-				},
-			})
-			if err != nil {
-				logger.Error(err, "unable to start manager")
-				os.Exit(1)
-			}
-
-			_ = manager.AddReadyzCheck("ping", healthz.Ping)
-			_ = manager.AddHealthzCheck("ping", healthz.Ping)
-
-			ctx := ctrl.SetupSignalHandler()
-
-			if err = (&controller.PropagationController{
-				Client:       manager.GetClient(),
-				TargetClient: targetClient,
-				Log:          ctrl.Log.WithName("controllers").WithName("Ingress"),
-				Recorder:     manager.GetEventRecorderFor("ingress-controller"),
-				Options: controller.PropagationControllerOptions{
-					Identifier:             options.identifier,
-					IngressClassName:       options.ingressClass,
-					TargetIngressClassName: options.targetIngressClass,
-					ControllerClassName:    options.controllerClass,
-					TargetNamespace:        options.targetNamespace,
-					TargetIssuerNamespaced: options.targetIssuerNamespaced,
-					TargetIssuerName:       options.targetIssuerName,
-					TLSrespect:             options.tlsRepsect,
-				},
-			}).SetupWithManager(ctx, manager); err != nil {
-				setupLog.Error(err, "unable to create controller", "controller", "Ingress")
-				os.Exit(1)
-			}
-
-			setupLog.Info("propagation manager start serving")
-
-			if err = manager.Start(ctx); err != nil {
-				setupLog.Error(err, "problem running manager")
-				os.Exit(1)
-			}
-
-			return nil
+			return runManager(&options)
 		},
 	}
+	rootCommand.AddCommand(renderCommand(&options))
+	rootCommand.AddCommand(diffCommand(&options))
+	rootCommand.AddCommand(pruneCommand(&options))
+	rootCommand.AddCommand(uninstallCommand(&options))
+	rootCommand.AddCommand(statusCommand(&options))
+	rootCommand.AddCommand(bootstrapCommand(&options))
+	rootCommand.AddCommand(doctorCommand(&options))
 
 	rootCommand.PersistentFlags().StringVar(&options.ingressClass, "ingress-class", options.ingressClass, "ingress class name")
 	rootCommand.PersistentFlags().StringVar(&options.controllerClass, "controller-class", options.controllerClass, "controller class name")
-	rootCommand.PersistentFlags().IntVarP(&options.logLevel, "log-level", "v", options.logLevel, "numeric log level")
+	rootCommand.PersistentFlags().IntVarP(&options.logging.Level, "log-level", "v", options.logging.Level, "numeric log level")
+	rootCommand.PersistentFlags().StringVar(&options.logging.Format, "log-format", options.logging.Format, "log format: text, json or console")
+	rootCommand.PersistentFlags().BoolVar(&options.logging.Sampling, "log-sampling", options.logging.Sampling, "sample repeated log messages of the json and console format")
 	rootCommand.PersistentFlags().StringVar(&options.targetIngressClass, "target-ingress-class", options.targetIngressClass, "Ingress Class on target cluster")
 	rootCommand.PersistentFlags().StringVar(&options.identifier, "identifier", options.identifier, "propagator identifier, if multiple propagators sync to the same target namespace, this should be different for each")
 	rootCommand.PersistentFlags().StringVar(&options.targetNamespace, "target-namespace", options.targetNamespace, "namespace on target cluster, where manifests are synced to")
 	rootCommand.PersistentFlags().StringVar(&options.targetKubeconfig, "target-kubeconfig", options.targetKubeconfig, "namespace on target cluster, where manifests are synced to")
+	rootCommand.PersistentFlags().StringVar(&options.kubeconfig, "kubeconfig", options.kubeconfig, "kubeconfig of the source cluster (Defaults to in-cluster config or KUBECONFIG)")
 	rootCommand.PersistentFlags().StringVar(&options.targetIssuerName, "target-issuer-name", options.targetIssuerName, "name of issuer added as cert-manager annotation on target cluster")
 	rootCommand.PersistentFlags().BoolVar(&options.targetIssuerNamespaced, "target-issuer-namespaced", false, "name of issuer added as cert-manager annotation on target cluster")
 	rootCommand.PersistentFlags().StringVar(&options.metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
@@ -147,8 +160,58 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	rootCommand.PersistentFlags().BoolVar(&options.tlsRepsect, "tls-respect", false, "Respect TLS Spec on ingress objects, if an issuer is defined the TLS spec is added anyway")
-	err := rootCommand.Execute()
-	if err != nil {
-		panic(err)
+	rootCommand.PersistentFlags().StringSliceVar(&options.targetIPFamilies, "target-ip-families", options.targetIPFamilies, "IP families (IPv4, IPv6) of loadbalancer addresses propagated to the target cluster")
+	rootCommand.PersistentFlags().BoolVar(&options.nodePortFallback, "nodeport-fallback", false, "Propagate node addresses and nodeports of the source cluster for services without loadbalancer address")
+	rootCommand.PersistentFlags().StringVar(&options.nodeSelector, "node-selector", options.nodeSelector, "label selector for nodes used by the nodeport fallback")
+	rootCommand.PersistentFlags().StringVar(&options.nodeAddressType, "node-address-type", options.nodeAddressType, "node address type (InternalIP, ExternalIP) used by the nodeport fallback")
+	rootCommand.PersistentFlags().StringVar(&options.probe.Type, "probe-type", options.probe.Type, "health probe (tcp, http, https) for propagated addresses, disabled if empty")
+	rootCommand.PersistentFlags().StringVar(&options.probe.Path, "probe-path", options.probe.Path, "request path of http and https health probes")
+	rootCommand.PersistentFlags().DurationVar(&options.probe.Interval, "probe-interval", options.probe.Interval, "interval between health probes")
+	rootCommand.PersistentFlags().DurationVar(&options.probe.Timeout, "probe-timeout", options.probe.Timeout, "timeout of a single health probe")
+	rootCommand.PersistentFlags().IntVar(&options.probe.SuccessThreshold, "probe-success-threshold", options.probe.SuccessThreshold, "consecutive successful probes until an address is ready again")
+	rootCommand.PersistentFlags().IntVar(&options.probe.FailureThreshold, "probe-failure-threshold", options.probe.FailureThreshold, "consecutive failed probes until an address is not ready")
+	rootCommand.PersistentFlags().BoolVar(&options.certificateSync, "certificate-sync", false, "Sync certificates issued on the target cluster back to the namespace of the origin ingress")
+	rootCommand.PersistentFlags().StringVar(&options.certificateSyncSuffix, "certificate-sync-suffix", options.certificateSyncSuffix, "suffix appended to the ingress name for synced certificate secrets, overwritten by the "+controller.AnnotationCertificateSecretName+" annotation")
+	rootCommand.PersistentFlags().StringVar(&options.certificateMode, "target-certificate-mode", options.certificateMode, "how certificates are requested on the target cluster: annotation (cert-manager ingress-shim) or certificate (explicit certificate resources)")
+	rootCommand.PersistentFlags().StringVar(&options.issuerKind, "target-issuer-kind", options.issuerKind, "kind of the issuer referenced by certificates (Defaults to Issuer or ClusterIssuer)")
+	rootCommand.PersistentFlags().StringVar(&options.issuerGroup, "target-issuer-group", options.issuerGroup, "group of the issuer referenced by certificates (Defaults to cert-manager.io)")
+	rootCommand.PersistentFlags().DurationVar(&options.certificateDuration, "certificate-duration", options.certificateDuration, "requested duration of certificates, issuer default if unset")
+	rootCommand.PersistentFlags().StringVar(&options.certificateKeyAlg, "certificate-key-algorithm", options.certificateKeyAlg, "private key algorithm (RSA, ECDSA, Ed25519) of certificates, issuer default if unset")
+	rootCommand.PersistentFlags().StringSliceVar(&options.certificateDNSNames, "certificate-dns-names", options.certificateDNSNames, "additional dns names added to all certificates")
+	rootCommand.PersistentFlags().StringVar(&options.tlsStrategy, "tls-strategy", options.tlsStrategy, "how hosts are split into certificates issued on the target cluster: combined, per-host or per-tls (one per origin tls entry, per host without tls entries)")
+	rootCommand.PersistentFlags().BoolVar(&options.waitForReadiness, "wait-for-readiness", false, "Wait for the target ingress address and certificates before reporting a propagation as ready")
+	rootCommand.PersistentFlags().DurationVar(&options.readinessTimeout, "readiness-timeout", options.readinessTimeout, "duration after which a propagation that is not ready is reported as degraded")
+	rootCommand.PersistentFlags().DurationVar(&options.readinessInterval, "readiness-interval", options.readinessInterval, "interval between readiness checks of a pending propagation")
+	rootCommand.PersistentFlags().BoolVar(&options.propagationStatus, "propagation-status", true, "Report the state of propagations as Propagation resources in the namespace of the origin ingress")
+	rootCommand.PersistentFlags().StringVar(&options.tracing.Endpoint, "otlp-endpoint", "", "OTLP gRPC endpoint (host:port) traces are exported to, tracing is enabled if set or OTEL_EXPORTER_OTLP_ENDPOINT is defined")
+	rootCommand.PersistentFlags().BoolVar(&options.tracing.Insecure, "otlp-insecure", false, "Disable TLS for the OTLP exporter")
+	rootCommand.PersistentFlags().Float64Var(&options.tracing.SampleRatio, "trace-sample-ratio", options.tracing.SampleRatio, "ratio of reconciles that are traced (0-1)")
+	rootCommand.PersistentFlags().StringSliceVar(&options.allowedDomains, "allowed-domains", options.allowedDomains, "domains hosts of propagated ingresses must be part of, all hosts are allowed if empty")
+	rootCommand.PersistentFlags().BoolVar(&options.webhook, "webhook", false, "Serve a validating admission webhook for ingresses of the controlled classes")
+	rootCommand.PersistentFlags().StringVar(&options.webhookMode, "webhook-mode", options.webhookMode, "how the webhook handles ingresses that can't be propagated: deny or warn")
+	rootCommand.PersistentFlags().IntVar(&options.webhookPort, "webhook-port", options.webhookPort, "port the webhook server listens on")
+	rootCommand.PersistentFlags().StringVar(&options.webhookCertDir, "webhook-cert-dir", options.webhookCertDir, "directory containing tls.crt and tls.key of the webhook server")
+	rootCommand.PersistentFlags().BoolVar(&options.dryRun, "dry-run", false, "Report changes to the target cluster as logs, events and metrics instead of applying them")
+	rootCommand.PersistentFlags().DurationVar(&options.backoffBase, "backoff-base", options.backoffBase, "initial delay before retrying an ingress after a transient error, doubled on every failure")
+	rootCommand.PersistentFlags().DurationVar(&options.backoffMax, "backoff-max", options.backoffMax, "maximum delay before retrying an ingress after transient errors")
+	rootCommand.PersistentFlags().DurationVar(&options.deletionTimeout, "deletion-timeout", options.deletionTimeout, "release deleted ingresses whose propagation can't be removed from the target cluster after the timeout, disabled if zero")
+	rootCommand.PersistentFlags().StringVar(&options.deletionPolicy, "deletion-policy", options.deletionPolicy, "what happens to the target objects once an ingress is deleted, unless overridden by annotation: Delete, Orphan (strip the managed labels) or Retain (keep them managed but untouched)")
+	rootCommand.PersistentFlags().StringVar(&options.pendingDeletions, "pending-deletions-configmap", options.pendingDeletions, "configmap (namespace/name) on the source cluster recording deletions deferred while the target cluster is unreachable, replayed once it's reachable again")
+	rootCommand.PersistentFlags().DurationVar(&options.targetHealth.Interval, "target-health-interval", options.targetHealth.Interval, "interval between checks of the target cluster API reported by the readiness probe")
+	rootCommand.PersistentFlags().DurationVar(&options.targetHealth.Timeout, "target-health-timeout", options.targetHealth.Timeout, "timeout of a single check of the target cluster API")
+	rootCommand.PersistentFlags().IntVar(&options.targetHealth.FailureThreshold, "target-health-failure-threshold", options.targetHealth.FailureThreshold, "consecutive failed checks until the target cluster is reported as unreachable")
+	rootCommand.PersistentFlags().BoolVar(&options.preflight, "preflight", true, "Verify permissions and objects on the target cluster at startup, refuse to start if a check fails")
+
+	// Installed as kubectl-propagator, "kubectl propagator" lists the propagations
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-propagator") {
+		rootCommand.Use = "kubectl-propagator"
+		if cmd, _, err := rootCommand.Find(os.Args[1:]); err == nil && cmd == &rootCommand {
+			rootCommand.SetArgs(append([]string{"status"}, os.Args[1:]...))
+		}
+	}
+
+	// Errors are printed by cobra
+	if err := rootCommand.Execute(); err != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/logging"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// setupLogging sets up the root logger shared by all subcommands
+func (o *rootCmdFlags) setupLogging() error {
+	rootLogger, err := logging.New(o.logging)
+	if err != nil {
+		return err
+	}
+	crlog.SetLogger(rootLogger.WithName("controller-runtime"))
+	o.logger = rootLogger.WithName("main")
+	o.logger.V(1).Info("logging verbosity", "verbosity", o.logging.Level, "format", o.logging.Format)
+	return nil
+}
+
+// controllerOptions validates the flags and returns the options of the propagation controller
+func (o *rootCmdFlags) controllerOptions() (controller.PropagationControllerOptions, error) {
+	if o.targetNamespace == "" {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("target namespace must be defined")
+	}
+
+	if o.backoffBase <= 0 || o.backoffMax < o.backoffBase {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("backoff base must be positive and not exceed the backoff max")
+	}
+
+	if o.deletionTimeout < 0 {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("deletion timeout must not be negative")
+	}
+
+	switch o.deletionPolicy {
+	case controller.DeletionPolicyDelete, controller.DeletionPolicyOrphan, controller.DeletionPolicyRetain:
+	default:
+		return controller.PropagationControllerOptions{}, fmt.Errorf("unknown deletion policy %s", o.deletionPolicy)
+	}
+
+	var ipFamilies []corev1.IPFamily
+	for _, family := range o.targetIPFamilies {
+		if family != string(corev1.IPv4Protocol) && family != string(corev1.IPv6Protocol) {
+			return controller.PropagationControllerOptions{}, fmt.Errorf("unknown ip family %s", family)
+		}
+		ipFamilies = append(ipFamilies, corev1.IPFamily(family))
+	}
+
+	nodeSelector, err := labels.Parse(o.nodeSelector)
+	if err != nil {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("invalid node selector: %s", err)
+	}
+
+	if o.certificateSync && o.targetIssuerName == "" {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("certificate sync requires a target issuer")
+	}
+
+	if o.certificateMode != controller.CertificateModeAnnotation && o.certificateMode != controller.CertificateModeResource {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("unknown certificate mode %s", o.certificateMode)
+	}
+
+	if o.webhookMode != controller.WebhookModeDeny && o.webhookMode != controller.WebhookModeWarn {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("unknown webhook mode %s", o.webhookMode)
+	}
+
+	switch o.tlsStrategy {
+	case controller.TLSStrategyCombined, controller.TLSStrategyPerHost, controller.TLSStrategyPerTLS:
+	default:
+		return controller.PropagationControllerOptions{}, fmt.Errorf("unknown tls strategy %s", o.tlsStrategy)
+	}
+
+	// Readiness reflects the target cluster, a check must be able to run and fail
+	if o.targetHealth.Interval <= 0 || o.targetHealth.Timeout <= 0 || o.targetHealth.FailureThreshold < 1 {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("target health interval, timeout and failure threshold must be positive")
+	}
+
+	if o.tracing.Enabled() && (o.tracing.SampleRatio < 0 || o.tracing.SampleRatio > 1) {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("trace sample ratio must be between 0 and 1")
+	}
+
+	if o.waitForReadiness && (o.readinessInterval <= 0 || o.readinessTimeout <= 0) {
+		return controller.PropagationControllerOptions{}, fmt.Errorf("readiness interval and timeout must be greater than 0")
+	}
+
+	if o.probe.Type != "" {
+		switch o.probe.Type {
+		case prober.ProbeTCP, prober.ProbeHTTP, prober.ProbeHTTPS:
+		default:
+			return controller.PropagationControllerOptions{}, fmt.Errorf("unknown probe type %s", o.probe.Type)
+		}
+		if o.probe.Interval <= 0 || o.probe.Timeout <= 0 || o.probe.SuccessThreshold < 1 || o.probe.FailureThreshold < 1 {
+			return controller.PropagationControllerOptions{}, fmt.Errorf("probe interval, timeout and thresholds must be positive")
+		}
+	}
+
+	return controller.PropagationControllerOptions{
+		Identifier:              o.identifier,
+		IngressClassName:        o.ingressClass,
+		TargetIngressClassName:  o.targetIngressClass,
+		ControllerClassName:     o.controllerClass,
+		TargetNamespace:         o.targetNamespace,
+		TargetIssuerNamespaced:  o.targetIssuerNamespaced,
+		TargetIssuerName:        o.targetIssuerName,
+		TLSrespect:              o.tlsRepsect,
+		TargetIPFamilies:        ipFamilies,
+		NodePortFallback:        o.nodePortFallback,
+		NodeSelector:            nodeSelector,
+		NodeAddressType:         corev1.NodeAddressType(o.nodeAddressType),
+		CertificateSync:         o.certificateSync,
+		CertificateSyncSuffix:   o.certificateSyncSuffix,
+		TargetCertificateMode:   o.certificateMode,
+		TargetIssuerKind:        o.issuerKind,
+		TargetIssuerGroup:       o.issuerGroup,
+		CertificateDuration:     o.certificateDuration,
+		CertificateKeyAlgorithm: o.certificateKeyAlg,
+		CertificateDNSNames:     o.certificateDNSNames,
+		TLSStrategy:             o.tlsStrategy,
+		WaitForReadiness:        o.waitForReadiness,
+		ReadinessTimeout:        o.readinessTimeout,
+		ReadinessInterval:       o.readinessInterval,
+		PropagationStatus:       o.propagationStatus,
+		AllowedDomains:          o.allowedDomains,
+		BackoffBase:             o.backoffBase,
+		BackoffMax:              o.backoffMax,
+		DeletionTimeout:         o.deletionTimeout,
+		DeletionPolicy:          o.deletionPolicy,
+		DryRun:                  o.dryRun,
+	}, nil
+}
+
+// namespacedName parses a namespace/name reference
+func namespacedName(value string) (types.NamespacedName, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("invalid reference %s, expected namespace/name", value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// leaderElectionID returns the lease name of the propagator. Propagators keep the lease of previous releases, a
+// rolling upgrade would elect two leaders otherwise. Dry runs elect their own leader per identifier.
+func (o *rootCmdFlags) leaderElectionID() string {
+	id := "2c123jea.buttah.cloud"
+	if !o.dryRun {
+		return id
+	}
+	if o.identifier != "" {
+		id = fmt.Sprintf("%s.%s", strings.ToLower(o.identifier), id)
+	}
+	return "dry-run." + id
+}
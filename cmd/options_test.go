@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/health"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
+)
+
+// validFlags returns flags passing the validation of controllerOptions
+func validFlags() rootCmdFlags {
+	return rootCmdFlags{
+		targetNamespace:   "propagator",
+		backoffBase:       time.Second,
+		backoffMax:        time.Minute,
+		deletionPolicy:    controller.DeletionPolicyDelete,
+		certificateMode:   controller.CertificateModeAnnotation,
+		webhookMode:       controller.WebhookModeDeny,
+		tlsStrategy:       controller.TLSStrategyCombined,
+		readinessInterval: 15 * time.Second,
+		readinessTimeout:  10 * time.Minute,
+		targetHealth:      health.Options{Interval: 30 * time.Second, Timeout: 10 * time.Second, FailureThreshold: 3},
+		probe:             prober.Options{Interval: 10 * time.Second, Timeout: 3 * time.Second, SuccessThreshold: 1, FailureThreshold: 3},
+	}
+}
+
+func TestControllerOptions(t *testing.T) {
+	// Tracing is enabled by the environment as well
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	tests := []struct {
+		name    string
+		mutate  func(o *rootCmdFlags)
+		wantErr bool
+	}{
+		{name: "valid", mutate: func(o *rootCmdFlags) {}},
+		{name: "no target namespace", mutate: func(o *rootCmdFlags) { o.targetNamespace = "" }, wantErr: true},
+		{name: "backoff max below base", mutate: func(o *rootCmdFlags) { o.backoffMax = time.Millisecond }, wantErr: true},
+		{name: "negative deletion timeout", mutate: func(o *rootCmdFlags) { o.deletionTimeout = -time.Second }, wantErr: true},
+		{name: "unknown deletion policy", mutate: func(o *rootCmdFlags) { o.deletionPolicy = "Keep" }, wantErr: true},
+		{name: "unknown ip family", mutate: func(o *rootCmdFlags) { o.targetIPFamilies = []string{"IPv5"} }, wantErr: true},
+		{name: "certificate sync without issuer", mutate: func(o *rootCmdFlags) { o.certificateSync = true }, wantErr: true},
+		{name: "unknown tls strategy", mutate: func(o *rootCmdFlags) { o.tlsStrategy = "per-path" }, wantErr: true},
+		{name: "zero target health interval", mutate: func(o *rootCmdFlags) { o.targetHealth.Interval = 0 }, wantErr: true},
+		{name: "zero target health threshold", mutate: func(o *rootCmdFlags) { o.targetHealth.FailureThreshold = 0 }, wantErr: true},
+		{name: "trace sample ratio above 1", mutate: func(o *rootCmdFlags) { o.tracing.Endpoint = "otel:4317"; o.tracing.SampleRatio = 2 }, wantErr: true},
+		{name: "trace sample ratio without tracing", mutate: func(o *rootCmdFlags) { o.tracing.SampleRatio = 2 }},
+		{name: "zero readiness interval", mutate: func(o *rootCmdFlags) { o.waitForReadiness = true; o.readinessInterval = 0 }, wantErr: true},
+		{name: "zero readiness interval without waiting", mutate: func(o *rootCmdFlags) { o.readinessInterval = 0 }},
+		{name: "unknown probe type", mutate: func(o *rootCmdFlags) { o.probe.Type = "udp" }, wantErr: true},
+		{name: "zero probe interval", mutate: func(o *rootCmdFlags) { o.probe.Type = prober.ProbeTCP; o.probe.Interval = 0 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := validFlags()
+			tt.mutate(&o)
+			if _, err := o.controllerOptions(); (err != nil) != tt.wantErr {
+				t.Errorf("controllerOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLeaderElectionID(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier string
+		dryRun     bool
+		want       string
+	}{
+		{name: "default", want: "2c123jea.buttah.cloud"},
+		{name: "identifier keeps the lease", identifier: "Shop", want: "2c123jea.buttah.cloud"},
+		{name: "dry run", identifier: "Shop", dryRun: true, want: "dry-run.shop.2c123jea.buttah.cloud"},
+		{name: "dry run without identifier", dryRun: true, want: "dry-run.2c123jea.buttah.cloud"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := rootCmdFlags{identifier: tt.identifier, dryRun: tt.dryRun}
+			if got := o.leaderElectionID(); got != tt.want {
+				t.Errorf("leaderElectionID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+func pruneCommand(options *rootCmdFlags) *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete target objects of the identifier which don't belong to any controlled ingress",
+		Long: "Deletes ingresses, services, endpoints, secrets and certificates managed by the identifier in the target namespace, " +
+			"whose origin ingress no longer exists or is no longer controlled. Only lists them with --dry-run.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			propagator, err := options.propagator()
+			if err != nil {
+				return err
+			}
+
+			props, err := controlledPropagations(ctx, propagator)
+			if err != nil {
+				return err
+			}
+			orphans, err := propagator.Orphans(ctx, propagationsOf(props))
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if len(orphans) == 0 {
+				fmt.Fprintf(out, "no orphaned objects in target namespace %s\n", options.targetNamespace)
+				return nil
+			}
+			printChanges(out, orphans)
+			if options.dryRun {
+				return nil
+			}
+			if !yes && !confirm(cmd.InOrStdin(), out, fmt.Sprintf("delete %d objects in target namespace %s?", len(orphans), options.targetNamespace)) {
+				return fmt.Errorf("aborted")
+			}
+			if err := propagator.Prune(ctx, orphans); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "deleted %d objects\n", len(orphans))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	return cmd
+}
+
+func printChanges(out io.Writer, changes []diff.Change) {
+	for _, change := range changes {
+		fmt.Fprintf(out, "%s\n", change)
+	}
+}
+
+// confirm asks a yes/no question, anything but y or yes is a no
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/spf13/cobra"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds read from manifests which aren't namespaced
+var clusterScopedKinds = map[string]bool{"IngressClass": true, "Node": true, "Namespace": true}
+
+func renderCommand(options *rootCmdFlags) *cobra.Command {
+	var files []string
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render the target manifests of ingresses read from files or stdin, without cluster access",
+		Long: "Reads Ingress, Service, Secret, IngressClass and Node manifests and prints the Ingress, Service, Endpoints " +
+			"and Certificate manifests propagated to the target cluster. Secrets are never printed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			controllerOptions, err := options.controllerOptions()
+			if err != nil {
+				return err
+			}
+
+			if len(files) == 0 {
+				files = []string{"-"}
+			}
+			var objects []client.Object
+			for _, file := range files {
+				read, err := readManifests(cmd.InOrStdin(), file, namespace)
+				if err != nil {
+					return err
+				}
+				objects = append(objects, read...)
+			}
+
+			// The ingress class of the propagator is usually not part of the manifests
+			objects = append(objects, &networkingv1.IngressClass{
+				ObjectMeta: metav1.ObjectMeta{Name: options.ingressClass},
+				Spec:       networkingv1.IngressClassSpec{Controller: options.controllerClass},
+			})
+			source := fake.NewClientBuilder().WithScheme(scheme).WithObjects(uniqueObjects(objects)...).Build()
+
+			propagator := &controller.PropagationController{
+				Client:   source,
+				Log:      options.logger.WithName("render"),
+				Recorder: &record.FakeRecorder{},
+				Options:  controllerOptions,
+			}
+			return render(cmd.Context(), propagator, cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&files, "filename", "f", files, "manifest files, - reads from stdin (Defaults to stdin)")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of manifests without namespace")
+	return cmd
+}
+
+// render prints the target manifests of all controlled ingresses of the source client
+func render(ctx context.Context, propagator *controller.PropagationController, out io.Writer, errOut io.Writer) error {
+	ingresses := networkingv1.IngressList{}
+	if err := propagator.Client.List(ctx, &ingresses); err != nil {
+		return err
+	}
+	sort.Slice(ingresses.Items, func(a, b int) bool {
+		return client.ObjectKeyFromObject(&ingresses.Items[a]).String() < client.ObjectKeyFromObject(&ingresses.Items[b]).String()
+	})
+
+	failed := false
+	for _, ingress := range ingresses.Items {
+		controlled, err := propagator.IsControlled(ctx, ingress)
+		if err != nil {
+			return err
+		}
+		if !controlled {
+			fmt.Fprintf(errOut, "skipping ingress %s/%s, it's not controlled by this propagator\n", ingress.Namespace, ingress.Name)
+			continue
+		}
+
+		prop, err := propagator.FromIngressToPropagation(ctx, propagator.Client, ingress)
+		if err != nil {
+			fmt.Fprintf(errOut, "ingress %s/%s can't be propagated: %s\n", ingress.Namespace, ingress.Name, err)
+			failed = true
+			continue
+		}
+
+		objects := []client.Object{&prop.Ingress}
+		for s := range prop.Services {
+			objects = append(objects, &prop.Services[s])
+		}
+		for e := range prop.Endpoints {
+			objects = append(objects, &prop.Endpoints[e])
+		}
+		for c := range prop.Certificates {
+			objects = append(objects, &prop.Certificates[c])
+		}
+		if err := printManifests(out, objects...); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errors.New("not all ingresses can be propagated")
+	}
+	return nil
+}
+
+// readManifests reads all objects of a multi document yaml or json file, lists are flattened
+func readManifests(stdin io.Reader, file string, namespace string) ([]client.Object, error) {
+	reader := stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	var objects []client.Object
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		document := unstructured.Unstructured{}
+		if err := decoder.Decode(&document.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("decode %s: %s", file, err)
+		}
+		if len(document.Object) == 0 {
+			continue
+		}
+
+		items := []unstructured.Unstructured{document}
+		if document.IsList() {
+			list, err := document.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("decode %s: %s", file, err)
+			}
+			items = list.Items
+		}
+
+		for _, item := range items {
+			obj, err := scheme.New(item.GroupVersionKind())
+			if err != nil {
+				return nil, fmt.Errorf("decode %s: %s", file, err)
+			}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, obj); err != nil {
+				return nil, fmt.Errorf("decode %s %s: %s", item.GetKind(), item.GetName(), err)
+			}
+			object, ok := obj.(client.Object)
+			if !ok {
+				return nil, fmt.Errorf("decode %s: unsupported kind %s", file, item.GetKind())
+			}
+			if object.GetNamespace() == "" && !clusterScopedKinds[item.GetKind()] {
+				object.SetNamespace(namespace)
+			}
+			object.SetResourceVersion("")
+			objects = append(objects, object)
+		}
+	}
+}
+
+// printManifests prints objects as multi document yaml, without fields maintained by the api server
+func printManifests(out io.Writer, objects ...client.Object) error {
+	for _, obj := range objects {
+		// Typed objects lack their kind, unstructured certificates are not part of the scheme
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if gvk.Empty() {
+			var err error
+			gvk, err = apiutil.GVKForObject(obj, scheme)
+			if err != nil {
+				return err
+			}
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj.DeepCopyObject())
+		if err != nil {
+			return err
+		}
+		manifest := unstructured.Unstructured{Object: content}
+		manifest.SetGroupVersionKind(gvk)
+		unstructured.RemoveNestedField(manifest.Object, "metadata", "creationTimestamp")
+		unstructured.RemoveNestedField(manifest.Object, "status")
+
+		b, err := yaml.Marshal(manifest.Object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueObjects drops objects defined more than once, the first definition wins
+func uniqueObjects(objects []client.Object) []client.Object {
+	seen := map[string]bool{}
+	var unique []client.Object
+	for _, obj := range objects {
+		gvk, _ := apiutil.GVKForObject(obj, scheme)
+		key := fmt.Sprintf("%s/%s", gvk.Kind, client.ObjectKeyFromObject(obj))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, obj)
+	}
+	return unique
+}
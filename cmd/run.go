@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/health"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/metrics"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// runManager starts the propagation controller, it's the default of the root command
+func runManager(options *rootCmdFlags) error {
+	logger := options.logger
+
+	controllerOptions, err := options.controllerOptions()
+	if err != nil {
+		return fmt.Errorf("invalid options: %s", err)
+	}
+
+	// Load the kubeconfig from the provided file path
+	target, err := clientcmd.BuildConfigFromFlags("", options.targetKubeconfig)
+	if err != nil {
+		return fmt.Errorf("unable to load target kubeconfig: %s", err)
+	}
+	targetClient, err := client.NewWithWatch(target, client.Options{})
+	if err != nil {
+		return fmt.Errorf("unable to set up target client: %s", err)
+	}
+	recorder := metrics.NewRecorder(options.identifier, target.Host)
+
+	source, err := options.sourceConfig()
+	if err != nil {
+		return fmt.Errorf("unable to load kubeconfig: %s", err)
+	}
+
+	manager, err := ctrl.NewManager(source, ctrl.Options{
+		Scheme: scheme,
+		Metrics: metricsserver.Options{
+			BindAddress: options.metricsAddr,
+		},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    options.webhookPort,
+			CertDir: options.webhookCertDir,
+		}),
+		LeaderElection:         options.enableLeaderElection,
+		LeaderElectionID:       options.leaderElectionID(),
+		HealthProbeBindAddress: ":10080",
+		NewClient: func(config *rest.Config, options client.Options) (client.Client, error) {
+			options.Cache.Unstructured = true
+			// Only tls secrets referenced by ingresses are read, caching all secrets of the cluster isn't worth it
+			options.Cache.DisableFor = append(options.Cache.DisableFor, &corev1.Secret{})
+			return client.New(config, options)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to start manager: %s", err)
+	}
+
+	_ = manager.AddReadyzCheck("ping", healthz.Ping)
+	_ = manager.AddHealthzCheck("ping", healthz.Ping)
+
+	// Readiness reflects the target cluster, liveness doesn't as restarts don't help during outages
+	targetChecker := &health.TargetChecker{
+		Options:     options.targetHealth,
+		Client:      targetClient,
+		Namespace:   options.targetNamespace,
+		Identifier:  options.identifier,
+		Target:      target.Host,
+		Log:         ctrl.Log.WithName("target-health"),
+		Recorder:    manager.GetEventRecorderFor("ingress-controller"),
+		EventObject: &networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: options.ingressClass}},
+	}
+	if err = manager.Add(targetChecker); err != nil {
+		return fmt.Errorf("unable to add target health check: %s", err)
+	}
+	_ = manager.AddReadyzCheck("target", targetChecker.Check)
+
+	ctx := ctrl.SetupSignalHandler()
+
+	if options.tracing.Enabled() {
+		shutdown, err := tracing.Setup(ctx, options.tracing)
+		if err != nil {
+			return fmt.Errorf("unable to set up tracing: %s", err)
+		}
+		defer func() {
+			// The signal context is done by now, flush with a fresh one
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(shutdownCtx); err != nil {
+				logger.Error(err, "unable to flush traces")
+			}
+		}()
+	}
+
+	// Cache of the target namespace, only required for watches on the target cluster
+	var targetCache cache.Cache
+	if options.certificateSync || options.certificateMode == controller.CertificateModeResource {
+		targetCache, err = cache.New(target, cache.Options{
+			DefaultNamespaces: map[string]cache.Config{
+				options.targetNamespace: {},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to set up target cache: %s", err)
+		}
+		if err = manager.Add(targetCache); err != nil {
+			return fmt.Errorf("unable to add target cache: %s", err)
+		}
+	}
+
+	var backendProber *prober.Prober
+	if options.probe.Type != "" {
+		backendProber = prober.New(options.probe, options.identifier, target.Host, ctrl.Log.WithName("prober"), manager.GetEventRecorderFor("ingress-controller"))
+		if err = manager.Add(backendProber); err != nil {
+			return fmt.Errorf("unable to add prober: %s", err)
+		}
+	}
+
+	propagator := &controller.PropagationController{
+		Client:       manager.GetClient(),
+		TargetClient: recorder.InstrumentClient(tracing.InstrumentClient(targetClient)),
+		Metrics:      recorder,
+		TargetCache:  targetCache,
+		Log:          ctrl.Log.WithName("controllers").WithName("Ingress"),
+		Recorder:     manager.GetEventRecorderFor("ingress-controller"),
+		Prober:       backendProber,
+		Options:      controllerOptions,
+	}
+	if options.pendingDeletions != "" {
+		key, err := namespacedName(options.pendingDeletions)
+		if err != nil {
+			return fmt.Errorf("invalid pending deletions configmap: %s", err)
+		}
+		// Config maps are read rarely, a cache of all config maps isn't worth it
+		sourceClient, err := client.New(source, client.Options{Scheme: scheme})
+		if err != nil {
+			return fmt.Errorf("unable to set up source client: %s", err)
+		}
+		propagator.PendingDeletions = &controller.PendingDeletions{
+			Client:   sourceClient,
+			Key:      key,
+			Interval: options.targetHealth.Interval,
+		}
+	}
+	if options.preflight {
+		results := propagator.Preflight(ctx)
+		for _, result := range results {
+			log := setupLog.WithValues("check", result.Name, "result", result.Status, "message", result.Message)
+			if result.Status == controller.CheckPass {
+				log.V(1).Info("preflight check")
+			} else {
+				log.Info("preflight check")
+			}
+		}
+		if controller.PreflightFailed(results) {
+			return fmt.Errorf("preflight checks failed, target cluster is not usable, run the doctor subcommand for details")
+		}
+	}
+
+	if err = propagator.SetupWithManager(ctx, manager); err != nil {
+		return fmt.Errorf("unable to create ingress controller: %s", err)
+	}
+
+	if options.webhook {
+		if err = (&controller.IngressValidator{
+			Controller: propagator,
+			Mode:       options.webhookMode,
+		}).SetupWithManager(manager); err != nil {
+			return fmt.Errorf("unable to create ingress webhook: %s", err)
+		}
+	}
+
+	setupLog.Info("propagation manager start serving")
+
+	if err = manager.Start(ctx); err != nil {
+		return fmt.Errorf("problem running manager: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of the status command
+const (
+	outputTable = "table"
+	outputWide  = "wide"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func statusCommand(options *rootCmdFlags) *cobra.Command {
+	var output string
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the controlled ingresses and the state of their propagation on the target cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch output {
+			case outputTable, outputWide, outputJSON, outputYAML:
+			default:
+				return fmt.Errorf("unsupported output %s, valid are %s, %s, %s and %s", output, outputTable, outputWide, outputJSON, outputYAML)
+			}
+
+			ctx := cmd.Context()
+			propagator, err := options.propagator()
+			if err != nil {
+				return err
+			}
+			props, err := controlledPropagations(ctx, propagator)
+			if err != nil {
+				return err
+			}
+
+			inspections := []controller.Inspection{}
+			for _, prop := range props {
+				if namespace != "" && prop.Origin.Namespace != namespace {
+					continue
+				}
+				inspection, err := propagator.Inspect(ctx, prop.Propagation, prop.err)
+				if err != nil {
+					return err
+				}
+				inspections = append(inspections, inspection)
+			}
+			return printInspections(cmd.OutOrStdout(), output, inspections)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable, "output format: table, wide, json or yaml")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "only list ingresses of this namespace (Defaults to all namespaces)")
+	return cmd
+}
+
+func printInspections(out io.Writer, output string, inspections []controller.Inspection) error {
+	switch output {
+	case outputJSON:
+		b, err := json.MarshalIndent(inspections, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", b)
+		return err
+	case outputYAML:
+		b, err := yaml.Marshal(inspections)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(b)
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	header := "NAMESPACE\tNAME\tTARGET\tHOSTS\tADDRESSES\tFINALIZER\tSTATE"
+	if output == outputWide {
+		header += "\tOBJECTS\tMESSAGE"
+	}
+	fmt.Fprintln(w, header)
+	for _, inspection := range inspections {
+		row := fmt.Sprintf("%s\t%s\t%s/%s\t%s\t%s\t%t\t%s", inspection.Namespace, inspection.Name,
+			inspection.TargetNamespace, inspection.TargetName, orNone(inspection.Hosts), orNone(inspection.Addresses),
+			inspection.Finalizer, inspection.State)
+		if output == outputWide {
+			objects := make([]string, 0, len(inspection.Objects))
+			for _, obj := range inspection.Objects {
+				objects = append(objects, fmt.Sprintf("%s/%s:%s", obj.Kind, obj.Name, obj.State))
+			}
+			row += fmt.Sprintf("\t%s\t%s", orNone(objects), inspection.Message)
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/controller"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func uninstallCommand(options *rootCmdFlags) *cobra.Command {
+	var yes bool
+	var keepTargetObjects bool
+
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove all propagations of the identifier and release the source ingresses",
+		Long: "Deletes all objects managed by the identifier in the target namespace, the propagation status resources " +
+			"and the " + controller.IngressControllerFinalizer + " finalizer of controlled ingresses. " +
+			"Stop the controller first, otherwise it propagates the ingresses again. Only lists the changes with --dry-run.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			out := cmd.OutOrStdout()
+			propagator, err := options.propagator()
+			if err != nil {
+				return err
+			}
+
+			// Without propagations all managed objects are orphans
+			targetObjects, err := propagator.Orphans(ctx, nil)
+			if err != nil {
+				return err
+			}
+
+			ingresses := networkingv1.IngressList{}
+			if err := propagator.Client.List(ctx, &ingresses); err != nil {
+				return err
+			}
+			var finalized []networkingv1.Ingress
+			for _, ingress := range ingresses.Items {
+				if !controllerutil.ContainsFinalizer(&ingress, controller.IngressControllerFinalizer) {
+					continue
+				}
+				// The ingress class may already be removed together with the controller
+				controlled := ingress.Spec.IngressClassName != nil && *ingress.Spec.IngressClassName == options.ingressClass
+				if !controlled {
+					if controlled, err = propagator.IsControlled(ctx, ingress); err != nil {
+						return err
+					}
+				}
+				if controlled {
+					finalized = append(finalized, ingress)
+				}
+			}
+
+			statuses := v1alpha1.PropagationList{}
+			if err := propagator.Client.List(ctx, &statuses, client.MatchingLabels{controller.LabelManaged: options.identifier}); err != nil {
+				return err
+			}
+
+			// Pending deletions are covered by the orphans, the record is obsolete
+			var pendingDeletions *corev1.ConfigMap
+			if options.pendingDeletions != "" {
+				key, err := namespacedName(options.pendingDeletions)
+				if err != nil {
+					return fmt.Errorf("invalid pending deletions configmap: %s", err)
+				}
+				pendingDeletions = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+			}
+
+			if !keepTargetObjects {
+				printChanges(out, targetObjects)
+			}
+			if pendingDeletions != nil {
+				fmt.Fprintf(out, "delete ConfigMap %s/%s\n", pendingDeletions.Namespace, pendingDeletions.Name)
+			}
+			for _, status := range statuses.Items {
+				fmt.Fprintf(out, "delete Propagation %s/%s\n", status.Namespace, status.Name)
+			}
+			for _, ingress := range finalized {
+				fmt.Fprintf(out, "remove finalizer of Ingress %s/%s\n", ingress.Namespace, ingress.Name)
+			}
+			if options.dryRun {
+				return nil
+			}
+			if !yes && !confirm(cmd.InOrStdin(), out, fmt.Sprintf("uninstall propagator %q?", options.identifier)) {
+				return fmt.Errorf("aborted")
+			}
+
+			// Finalizers are removed last, they keep the origins around if removing the target objects fails
+			if !keepTargetObjects {
+				if err := propagator.Prune(ctx, targetObjects); err != nil {
+					return err
+				}
+			}
+			for _, status := range statuses.Items {
+				if err := propagator.Client.Delete(ctx, &status); err != nil && !k8serrors.IsNotFound(err) {
+					return fmt.Errorf("failed to delete propagation %s in namespace %s: %s", status.Name, status.Namespace, err)
+				}
+			}
+			if pendingDeletions != nil {
+				if err := propagator.Client.Delete(ctx, pendingDeletions); err != nil && !k8serrors.IsNotFound(err) {
+					return fmt.Errorf("failed to delete configmap %s in namespace %s: %s", pendingDeletions.Name, pendingDeletions.Namespace, err)
+				}
+			}
+			for n := range finalized {
+				if _, err := propagator.RemoveFinalizer(ctx, &finalized[n]); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(out, "propagator %q uninstalled\n", options.identifier)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	cmd.Flags().BoolVar(&keepTargetObjects, "keep-target-objects", false, "keep the objects in the target namespace, only release the source cluster")
+	return cmd
+}
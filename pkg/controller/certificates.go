@@ -0,0 +1,317 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// syncCertificates copies the certificates issued on the target cluster back to the namespace of the origin ingress
+func (i *PropagationController) syncCertificates(ctx context.Context, prop propagation.Propagation) error {
+	desired := map[string]bool{}
+	for _, name := range prop.IssuedSecrets {
+		issued := corev1.Secret{}
+		err := i.TargetClient.Get(ctx, types.NamespacedName{Namespace: i.Options.TargetNamespace, Name: name}, &issued)
+		if err != nil {
+			// Not yet issued, the secret watch triggers the sync once it is
+			if k8serrors.IsNotFound(err) {
+				desired = nil
+				continue
+			}
+			return fmt.Errorf("failed to get secret %s on target cluster: %s", name, err)
+		}
+
+		secretName := i.certificateSecretName(prop.Origin)
+		if len(prop.IssuedSecrets) > 1 {
+			secretName = fmt.Sprintf("%s-%s", secretName, strings.TrimPrefix(name, prop.PropagatedName+"-"))
+		}
+		if desired != nil {
+			desired[secretName] = true
+		}
+
+		secret := corev1.Secret{}
+		err = i.Client.Get(ctx, types.NamespacedName{Namespace: prop.Origin.Namespace, Name: secretName}, &secret)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get secret %s/%s: %s", prop.Origin.Namespace, secretName, err)
+		}
+		exists := err == nil
+
+		// Secrets not written by the propagator are never overwritten
+		if exists && !i.ownsSecret(secret, prop) {
+			owner := secret.Labels[LabelManaged]
+			if owner == "" {
+				owner = "another owner"
+			}
+			return &ConflictError{Kind: "Secret", Name: fmt.Sprintf("%s/%s", secret.Namespace, secret.Name), Owner: owner, Cluster: "source"}
+		}
+
+		if exists && reflect.DeepEqual(secret.Data, issued.Data) {
+			continue
+		}
+
+		secret.Name, secret.Namespace = secretName, prop.Origin.Namespace
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[LabelManaged] = i.Options.Identifier
+		secret.Labels[LabelPropagator] = prop.Name
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = issued.Data
+		if err := controllerutil.SetOwnerReference(&prop.Origin, &secret, i.Client.Scheme()); err != nil {
+			return fmt.Errorf("failed to set owner of secret %s/%s: %s", secret.Namespace, secret.Name, err)
+		}
+
+		if exists {
+			err = i.Client.Update(ctx, &secret)
+		} else {
+			err = i.Client.Create(ctx, &secret)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to sync secret %s/%s: %s", secret.Namespace, secret.Name, err)
+		}
+
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "CertificateSynced", "Certificate %s has been synced to secret %s", name, secretName)
+	}
+
+	// Stale secrets are kept until all certificates are issued, eg. the unsuffixed secret of a single certificate
+	if desired == nil {
+		return nil
+	}
+	return i.pruneCertificateSecrets(ctx, prop, desired)
+}
+
+// pruneCertificateSecrets deletes the synced secrets of a propagation that are no longer desired
+func (i *PropagationController) pruneCertificateSecrets(ctx context.Context, prop propagation.Propagation, desired map[string]bool) error {
+	secrets := corev1.SecretList{}
+	err := i.Client.List(ctx, &secrets, client.InNamespace(prop.Origin.Namespace), client.MatchingLabels{LabelManaged: i.Options.Identifier, LabelPropagator: prop.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list secrets in %s: %s", prop.Origin.Namespace, err)
+	}
+	for _, secret := range secrets.Items {
+		if desired[secret.Name] {
+			continue
+		}
+		if err := i.Client.Delete(ctx, &secret); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s/%s: %s", secret.Namespace, secret.Name, err)
+		}
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "CertificateRemoved", "Secret %s is no longer synced and has been removed", secret.Name)
+	}
+	return nil
+}
+
+// ownsSecret returns whether a secret in the origin namespace was written for the origin ingress
+func (i *PropagationController) ownsSecret(secret corev1.Secret, prop propagation.Propagation) bool {
+	if secret.Labels[LabelManaged] == i.Options.Identifier && secret.Labels[LabelPropagator] == prop.Name {
+		return true
+	}
+	for _, ref := range secret.OwnerReferences {
+		if ref.UID == prop.Origin.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateFor returns a cert-manager certificate issuing the given secret on the target cluster
+func (i *PropagationController) certificateFor(prop *propagation.Propagation, secretName string, hosts []string) unstructured.Unstructured {
+	kind, group := i.Options.issuerKind()
+
+	var dnsNames []interface{}
+	for _, host := range append(append([]string{}, hosts...), i.Options.CertificateDNSNames...) {
+		if !containsInterface(dnsNames, host) {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+
+	spec := map[string]interface{}{
+		"secretName": secretName,
+		"dnsNames":   dnsNames,
+		"issuerRef": map[string]interface{}{
+			"name":  i.Options.TargetIssuerName,
+			"kind":  kind,
+			"group": group,
+		},
+	}
+	if i.Options.CertificateDuration > 0 {
+		spec["duration"] = i.Options.CertificateDuration.String()
+	}
+	if i.Options.CertificateKeyAlgorithm != "" {
+		spec["privateKey"] = map[string]interface{}{
+			"algorithm": i.Options.CertificateKeyAlgorithm,
+		}
+	}
+
+	certificate := unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	certificate.SetGroupVersionKind(CertificateGVK)
+	certificate.SetName(secretName)
+	certificate.SetNamespace(i.Options.TargetNamespace)
+	certificate.SetLabels(map[string]string{
+		LabelManaged:    i.Options.Identifier,
+		LabelPropagator: prop.Name,
+	})
+	certificate.SetAnnotations(map[string]string{
+		AnnotationOrigin: fmt.Sprintf("%s/%s", prop.Origin.Namespace, prop.Origin.Name),
+	})
+	return certificate
+}
+
+// certificateTracker tracks the last reported ready condition of the certificates of each origin
+type certificateTracker struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]map[string]metav1.ConditionStatus
+}
+
+// observe records the ready condition of a certificate and returns whether it changed
+func (t *certificateTracker) observe(origin networkingv1.Ingress, name string, status metav1.ConditionStatus) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.states == nil {
+		t.states = map[types.NamespacedName]map[string]metav1.ConditionStatus{}
+	}
+
+	key := types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name}
+	if t.states[key] == nil {
+		t.states[key] = map[string]metav1.ConditionStatus{}
+	}
+	last, ok := t.states[key][name]
+	t.states[key][name] = status
+	return !ok || last != status
+}
+
+func (t *certificateTracker) forget(origin networkingv1.Ingress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.states, types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name})
+}
+
+// reportCertificates records changes of the ready condition of the propagated certificates as events on the origin ingress
+func (i *PropagationController) reportCertificates(ctx context.Context, prop propagation.Propagation) error {
+	for _, desired := range prop.Certificates {
+		certificate := unstructured.Unstructured{}
+		certificate.SetGroupVersionKind(CertificateGVK)
+		err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&desired), &certificate)
+		if err != nil {
+			return fmt.Errorf("failed to get certificate %s on target cluster: %s", desired.GetName(), err)
+		}
+
+		status, reason, message := certificateReady(certificate)
+		if !i.certificates.observe(prop.Origin, certificate.GetName(), status) {
+			continue
+		}
+		switch status {
+		case metav1.ConditionTrue:
+			i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "CertificateReady", "Certificate %s is ready: %s", certificate.GetName(), message)
+		case metav1.ConditionFalse:
+			i.Recorder.Eventf(&prop.Origin, corev1.EventTypeWarning, "CertificateNotReady", "Certificate %s is not ready (%s): %s", certificate.GetName(), reason, message)
+		}
+	}
+	return nil
+}
+
+// certificateReady returns the status, reason and message of the ready condition of a certificate
+func certificateReady(certificate unstructured.Unstructured) (metav1.ConditionStatus, string, string) {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		return metav1.ConditionStatus(status), reason, message
+	}
+	return metav1.ConditionUnknown, "", ""
+}
+
+// certificateSecretName returns the name of the secret in the origin namespace holding the synced certificate
+func (i *PropagationController) certificateSecretName(origin networkingv1.Ingress) string {
+	if name := origin.GetAnnotations()[AnnotationCertificateSecretName]; name != "" {
+		return name
+	}
+	return origin.Name + i.Options.CertificateSyncSuffix
+}
+
+// originsForTargetSecret returns requests for the origin ingresses of target ingresses referencing a secret
+func (i *PropagationController) originsForTargetSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	list := networkingv1.IngressList{}
+	err := i.TargetCache.List(ctx, &list, client.InNamespace(secret.GetNamespace()), client.MatchingLabels{
+		LabelManaged: i.Options.Identifier,
+	})
+	if err != nil {
+		i.logFor(nil).Error(err, "unable to list target ingresses for secret", "secret", client.ObjectKeyFromObject(secret))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, ingress := range list.Items {
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName != secret.GetName() {
+				continue
+			}
+			if origin, ok := originOf(&ingress); ok {
+				requests = append(requests, reconcile.Request{NamespacedName: origin})
+			}
+			break
+		}
+	}
+	return requests
+}
+
+// originForTargetObject returns a request for the origin ingress of a target object
+func (i *PropagationController) originForTargetObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	if origin, ok := originOf(obj); ok {
+		return []reconcile.Request{{NamespacedName: origin}}
+	}
+	return nil
+}
+
+// originOf returns the origin ingress of a target object
+func originOf(obj client.Object) (types.NamespacedName, bool) {
+	namespace, name, found := strings.Cut(obj.GetAnnotations()[AnnotationOrigin], "/")
+	if !found {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+func containsInterface(slice []interface{}, element string) bool {
+	for _, sliceElement := range slice {
+		if sliceElement == element {
+			return true
+		}
+	}
+	return false
+}
+
+// issuerKind returns the kind and group of the issuer referenced by certificates
+func (o PropagationControllerOptions) issuerKind() (string, string) {
+	kind := o.TargetIssuerKind
+	if kind == "" {
+		kind = "ClusterIssuer"
+		if o.TargetIssuerNamespaced {
+			kind = "Issuer"
+		}
+	}
+	group := o.TargetIssuerGroup
+	if group == "" {
+		group = CertificateGVK.Group
+	}
+	return kind, group
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSyncCertificates(t *testing.T) {
+	origin := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", UID: "origin-uid"}}
+	issued := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "id-shop-tls", Namespace: "target"},
+		Data:       map[string][]byte{"tls.crt": []byte("issued")},
+	}
+	existing := func(labels map[string]string, owners ...metav1.OwnerReference) []client.Object {
+		return []client.Object{&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop-tls", Namespace: "default", Labels: labels, OwnerReferences: owners},
+			Data:       map[string][]byte{"tls.crt": []byte("existing")},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		source   []client.Object
+		conflict bool
+		want     string
+	}{
+		{name: "created", want: "issued"},
+		{name: "managed secret updated", source: existing(map[string]string{LabelManaged: "id", LabelPropagator: "shop"}), want: "issued"},
+		{name: "secret of other ingress kept", source: existing(map[string]string{LabelManaged: "id", LabelPropagator: "blog"}), conflict: true, want: "existing"},
+		{name: "owned secret updated", source: existing(nil, metav1.OwnerReference{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "shop", UID: "origin-uid"}), want: "issued"},
+		{name: "unmanaged secret kept", source: existing(map[string]string{"app": "shop"}), conflict: true, want: "existing"},
+		{name: "secret of other propagator kept", source: existing(map[string]string{LabelManaged: "other"}), conflict: true, want: "existing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{CertificateSync: true, CertificateSyncSuffix: "-tls"}, tt.source, []client.Object{issued})
+			prop := propagation.Propagation{Name: "shop", PropagatedName: "id-shop", Origin: origin, IssuedSecrets: []string{"id-shop-tls"}}
+
+			err := i.syncCertificates(context.Background(), prop)
+			var conflict *ConflictError
+			if errors.As(err, &conflict) != tt.conflict {
+				t.Fatalf("syncCertificates() error = %v, want conflict %v", err, tt.conflict)
+			}
+			if err != nil && !tt.conflict {
+				t.Fatal(err)
+			}
+
+			secret := corev1.Secret{}
+			if err := i.Client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "shop-tls"}, &secret); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(secret.Data["tls.crt"]); got != tt.want {
+				t.Errorf("secret data = %q, want %q", got, tt.want)
+			}
+			if !tt.conflict && secret.Labels[LabelManaged] != "id" {
+				t.Errorf("synced secret is not labelled as managed: %v", secret.Labels)
+			}
+		})
+	}
+}
+
+func TestSyncCertificatesPrunesStale(t *testing.T) {
+	origin := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", UID: "origin-uid"}}
+	issued := func(name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "target"}, Data: map[string][]byte{"tls.crt": []byte(name)}}
+	}
+	synced := func(name string, propagator string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{LabelManaged: "id", LabelPropagator: propagator}}}
+	}
+
+	tests := []struct {
+		name   string
+		target []client.Object
+		want   []string
+	}{
+		{
+			name:   "single secret replaced by suffixed secrets",
+			target: []client.Object{issued("id-shop-a"), issued("id-shop-b")},
+			want:   []string{"blog-tls", "shop-tls-a", "shop-tls-b"},
+		},
+		{
+			name:   "kept until all certificates are issued",
+			target: []client.Object{issued("id-shop-a")},
+			want:   []string{"blog-tls", "shop-tls", "shop-tls-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{CertificateSync: true, CertificateSyncSuffix: "-tls"}, []client.Object{
+				synced("shop-tls", "shop"),
+				synced("blog-tls", "blog"),
+			}, tt.target)
+			prop := propagation.Propagation{Name: "shop", PropagatedName: "id-shop", Origin: origin, IssuedSecrets: []string{"id-shop-a", "id-shop-b"}}
+
+			ctx := context.Background()
+			if err := i.syncCertificates(ctx, prop); err != nil {
+				t.Fatal(err)
+			}
+			secrets := corev1.SecretList{}
+			if err := i.Client.List(ctx, &secrets, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, secret := range secrets.Items {
+				names = append(names, secret.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("secrets = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportCertificates(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string
+		events int
+	}{
+		{name: "ready once", states: []string{"True", "True", "True"}, events: 1},
+		{name: "not ready once", states: []string{"False", "False"}, events: 1},
+		{name: "becomes ready", states: []string{"False", "False", "True", "True"}, events: 2},
+		{name: "flapping", states: []string{"True", "False", "True"}, events: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate := unstructured.Unstructured{}
+			certificate.SetGroupVersionKind(CertificateGVK)
+			certificate.SetName("id-shop-tls")
+			certificate.SetNamespace("target")
+			i := testController(PropagationControllerOptions{}, nil, []client.Object{certificate.DeepCopy()})
+			recorder := i.Recorder.(*record.FakeRecorder)
+			prop := propagation.Propagation{
+				Name:         "shop",
+				Origin:       networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"}},
+				Certificates: []unstructured.Unstructured{certificate},
+			}
+
+			ctx := context.Background()
+			for _, state := range tt.states {
+				current := unstructured.Unstructured{}
+				current.SetGroupVersionKind(CertificateGVK)
+				if err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&certificate), &current); err != nil {
+					t.Fatal(err)
+				}
+				conditions := []interface{}{map[string]interface{}{"type": "Ready", "status": state, "reason": "Issuing"}}
+				if err := unstructured.SetNestedSlice(current.Object, conditions, "status", "conditions"); err != nil {
+					t.Fatal(err)
+				}
+				if err := i.TargetClient.Update(ctx, &current); err != nil {
+					t.Fatal(err)
+				}
+				if err := i.reportCertificates(ctx, prop); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := len(recorder.Events); got != tt.events {
+				t.Errorf("events = %d, want %d", got, tt.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Prune deletes the objects of all delete changes from the target namespace, other changes are ignored
+func (i *PropagationController) Prune(ctx context.Context, changes []diff.Change) error {
+	for _, change := range changes {
+		if change.Action != diff.ActionDelete {
+			continue
+		}
+		obj, err := emptyOfKind(change.Kind)
+		if err != nil {
+			return err
+		}
+		obj.SetName(change.Name)
+		obj.SetNamespace(i.Options.TargetNamespace)
+		err = i.TargetClient.Delete(ctx, obj)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s in namespace %s: %s", strings.ToLower(change.Kind), change.Name, i.Options.TargetNamespace, err)
+		}
+	}
+	return nil
+}
+
+// RemoveFinalizer releases an origin ingress without touching its propagation, returns false if it had no finalizer
+func (i *PropagationController) RemoveFinalizer(ctx context.Context, ingress *networkingv1.Ingress) (bool, error) {
+	if !controllerutil.ContainsFinalizer(ingress, IngressControllerFinalizer) {
+		return false, nil
+	}
+	patch := client.MergeFrom(ingress.DeepCopy())
+	controllerutil.RemoveFinalizer(ingress, IngressControllerFinalizer)
+	if err := i.Client.Patch(ctx, ingress, patch); err != nil {
+		return false, fmt.Errorf("failed to remove finalizer of ingress %s in namespace %s: %s", ingress.Name, ingress.Namespace, err)
+	}
+	return true, nil
+}
+
+// deletionPolicy returns the deletion policy of an origin ingress, unknown policies of the annotation are ignored
+func (i *PropagationController) deletionPolicy(origin networkingv1.Ingress) string {
+	if policy := origin.Annotations[AnnotationDeletionPolicy]; validDeletionPolicy(policy) {
+		return policy
+	}
+	if i.Options.DeletionPolicy == "" {
+		return DeletionPolicyDelete
+	}
+	return i.Options.DeletionPolicy
+}
+
+func validDeletionPolicy(policy string) bool {
+	return policy == DeletionPolicyDelete || policy == DeletionPolicyOrphan || policy == DeletionPolicyRetain
+}
+
+// removeTarget removes a propagation from the target cluster according to the deletion policy. Deleting the
+// target ingress removes the owned objects as well, orphaned and retained objects are updated instead.
+func (i *PropagationController) removeTarget(ctx context.Context, prop propagation.Propagation, policy string) error {
+	if policy != DeletionPolicyOrphan && policy != DeletionPolicyRetain {
+		err := i.TargetClient.Delete(ctx, &prop.Ingress)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete ingress %s: %s", prop.Ingress.Name, err)
+		}
+		return nil
+	}
+
+	objects, err := i.listTargetObjects(ctx, client.MatchingLabels{LabelManaged: i.Options.Identifier, LabelPropagator: prop.Name}, false)
+	if err != nil {
+		return err
+	}
+	ingress := &networkingv1.Ingress{}
+	err = i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), ingress)
+	if err == nil {
+		objects = append(objects, targetObject{kind: "Ingress", object: ingress})
+	} else if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get ingress on target cluster %s: %s", prop.Ingress.Name, err)
+	}
+
+	// The ingress is updated last, it's found again if updating its objects fails
+	for _, obj := range objects {
+		if policy == DeletionPolicyOrphan {
+			// The origin annotation is kept, an ingress of the same origin adopts the objects again
+			labels := obj.object.GetLabels()
+			delete(labels, LabelManaged)
+			delete(labels, LabelPropagator)
+			obj.object.SetLabels(labels)
+		} else {
+			annotations := obj.object.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[AnnotationRetained] = "true"
+			obj.object.SetAnnotations(annotations)
+		}
+		if err := i.TargetClient.Update(ctx, obj.object); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to update %s %s in namespace %s: %s", strings.ToLower(obj.kind), obj.object.GetName(), i.Options.TargetNamespace, err)
+		}
+	}
+	return nil
+}
+
+// emptyOfKind returns an empty object of a kind propagated to the target cluster
+func emptyOfKind(kind string) (client.Object, error) {
+	switch kind {
+	case "Ingress":
+		return &networkingv1.Ingress{}, nil
+	case "Service":
+		return &corev1.Service{}, nil
+	case "Endpoints":
+		return &corev1.Endpoints{}, nil
+	case "Secret":
+		return &corev1.Secret{}, nil
+	case CertificateGVK.Kind:
+		certificate := &unstructured.Unstructured{}
+		certificate.SetGroupVersionKind(CertificateGVK)
+		return certificate, nil
+	}
+	return nil, fmt.Errorf("unknown target kind %s", kind)
+}
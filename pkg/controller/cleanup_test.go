@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		option     string
+		annotation string
+		want       string
+	}{
+		{name: "default", want: DeletionPolicyDelete},
+		{name: "option", option: DeletionPolicyRetain, want: DeletionPolicyRetain},
+		{name: "annotation overrides option", option: DeletionPolicyRetain, annotation: DeletionPolicyOrphan, want: DeletionPolicyOrphan},
+		{name: "unknown annotation ignored", option: DeletionPolicyRetain, annotation: "Keep", want: DeletionPolicyRetain},
+		{name: "policies are case sensitive", annotation: "orphan", want: DeletionPolicyDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{DeletionPolicy: tt.option}, nil, nil)
+			origin := testIngress("shop", "shop.example.com")
+			if tt.annotation != "" {
+				origin.Annotations = map[string]string{AnnotationDeletionPolicy: tt.annotation}
+			}
+			if got := i.deletionPolicy(origin); got != tt.want {
+				t.Errorf("deletionPolicy() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveTargetOrphanAdoption(t *testing.T) {
+	ctx := context.Background()
+	i := testController(PropagationControllerOptions{TargetIPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+	}, nil)
+	// The fake client refuses updates without a resource version, the API server accepts them for these kinds
+	i.TargetClient = interceptor.NewClient(i.TargetClient.(client.WithWatch), interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if obj.GetResourceVersion() == "" {
+				current := obj.DeepCopyObject().(client.Object)
+				if err := c.Get(ctx, client.ObjectKeyFromObject(obj), current); err == nil {
+					obj.SetResourceVersion(current.GetResourceVersion())
+				}
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+	})
+	prop, err := i.FromIngressToPropagation(ctx, i.Client, testIngress("shop", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.putPropagation(ctx, prop); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.removeTarget(ctx, prop, DeletionPolicyOrphan); err != nil {
+		t.Fatal(err)
+	}
+	ingress := networkingv1.Ingress{}
+	if err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &ingress); err != nil {
+		t.Fatalf("orphaned ingress was deleted: %s", err)
+	}
+	if _, ok := ingress.Labels[LabelManaged]; ok {
+		t.Errorf("orphaned ingress is still managed: %v", ingress.Labels)
+	}
+	if ingress.Annotations[AnnotationOrigin] != "default/shop" {
+		t.Errorf("orphaned ingress lost its origin: %v", ingress.Annotations)
+	}
+	changes, err := i.Orphans(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("orphaned objects are pruned: %v", changes)
+	}
+
+	// The ingress of another origin with the same target name conflicts
+	other := prop
+	other.Ingress = *prop.Ingress.DeepCopy()
+	other.Ingress.Annotations[AnnotationOrigin] = "other/shop"
+	var conflict *ConflictError
+	if err := i.putPropagation(ctx, other); !errors.As(err, &conflict) {
+		t.Errorf("putPropagation() of another origin error = %v, want conflict", err)
+	}
+
+	// The recreated origin adopts the objects again
+	prop, err = i.FromIngressToPropagation(ctx, i.Client, testIngress("shop", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.putPropagation(ctx, prop); err != nil {
+		t.Fatalf("putPropagation() of the same origin error = %v, want adoption", err)
+	}
+	if err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &ingress); err != nil {
+		t.Fatal(err)
+	}
+	if ingress.Labels[LabelManaged] != "id" {
+		t.Errorf("adopted ingress is not managed: %v", ingress.Labels)
+	}
+}
+
+func TestRemoveTargetRetain(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{LabelManaged: "id", LabelPropagator: "shop"}
+	i := testController(PropagationControllerOptions{}, nil, []client.Object{
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target", Labels: labels, Annotations: map[string]string{AnnotationOrigin: "default/shop"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-shop-web", Namespace: "target", Labels: labels}},
+	})
+	prop := propagation.Propagation{Name: "shop", Ingress: networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target"}}}
+	if err := i.removeTarget(ctx, prop, DeletionPolicyRetain); err != nil {
+		t.Fatal(err)
+	}
+	service := corev1.Service{}
+	if err := i.TargetClient.Get(ctx, client.ObjectKey{Namespace: "target", Name: "id-shop-web"}, &service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Annotations[AnnotationRetained] != "true" || service.Labels[LabelManaged] != "id" {
+		t.Errorf("service is not retained: %v %v", service.Labels, service.Annotations)
+	}
+	changes, err := i.Orphans(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("retained objects are pruned: %v", changes)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	managed := func(propagator string) map[string]string {
+		return map[string]string{LabelManaged: "id", LabelPropagator: propagator}
+	}
+	i := testController(PropagationControllerOptions{}, nil, []client.Object{
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target", Labels: managed("shop"), Annotations: map[string]string{AnnotationOrigin: "default/shop"}}},
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-gone", Namespace: "target", Labels: managed("gone"), Annotations: map[string]string{AnnotationOrigin: "default/gone"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-shop-web", Namespace: "target", Labels: managed("shop")}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-gone-web", Namespace: "target", Labels: managed("gone")}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-kept-web", Namespace: "target", Labels: managed("kept"), Annotations: map[string]string{AnnotationRetained: "true"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other-web", Namespace: "target", Labels: map[string]string{LabelManaged: "other", LabelPropagator: "gone"}}},
+	})
+	props := []propagation.Propagation{{
+		Name:    "shop",
+		Origin:  networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"}},
+		Ingress: networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target"}},
+	}}
+
+	changes, err := i.Orphans(context.Background(), props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, change := range changes {
+		got[change.Kind+"/"+change.Name] = true
+	}
+	want := map[string]bool{"Ingress/id-gone": true, "Service/id-gone-web": true}
+	if len(got) != len(want) {
+		t.Errorf("Orphans() = %v, want %v", got, want)
+	}
+	for name := range want {
+		if !got[name] {
+			t.Errorf("Orphans() is missing %s, got %v", name, got)
+		}
+	}
+}
@@ -4,10 +4,24 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/tracing"
+
 	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-func (i *PropagationController) isControlledByThisController(ctx context.Context, target networkingv1.Ingress) (bool, error) {
+// IsControlled returns whether an ingress is of a class controlled by this propagator
+func (i *PropagationController) IsControlled(ctx context.Context, ingress networkingv1.Ingress) (bool, error) {
+	return i.isControlledByThisController(ctx, ingress)
+}
+
+func (i *PropagationController) isControlledByThisController(ctx context.Context, target networkingv1.Ingress) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "isControlledByThisController",
+		tracing.AttributeIngress.String(fmt.Sprintf("%s/%s", target.Namespace, target.Name)),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	if i.Options.IngressClassName == target.GetAnnotations()[WellKnownIngressAnnotation] {
 		return true, nil
 	}
@@ -45,3 +59,24 @@ func (i *PropagationController) listControlledIngressClasses(ctx context.Context
 
 	return controlledNames, nil
 }
+
+// ingressesForSecret returns requests for all ingresses referencing a secret in their tls spec
+func (i *PropagationController) ingressesForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	list := networkingv1.IngressList{}
+	err := i.Client.List(ctx, &list, client.InNamespace(secret.GetNamespace()))
+	if err != nil {
+		i.logFor(nil).Error(err, "unable to list ingresses for secret", "secret", client.ObjectKeyFromObject(secret))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, ingress := range list.Items {
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName == secret.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ingress)})
+				break
+			}
+		}
+	}
+	return requests
+}
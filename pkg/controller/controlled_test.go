@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestIngressesForSecret(t *testing.T) {
+	withTLS := func(name string, namespace string, secrets ...string) *networkingv1.Ingress {
+		ingress := testIngress(name, "shop.example.com")
+		ingress.Namespace = namespace
+		for _, secret := range secrets {
+			ingress.Spec.TLS = append(ingress.Spec.TLS, networkingv1.IngressTLS{SecretName: secret})
+		}
+		return &ingress
+	}
+	i := testController(PropagationControllerOptions{}, []client.Object{
+		withTLS("shop", "default", "other", "shop-tls"),
+		withTLS("blog", "default"),
+		withTLS("shop", "staging", "shop-tls"),
+	}, nil)
+
+	// Secrets are watched by metadata only
+	secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "shop-tls", Namespace: "default"}}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+
+	requests := i.ingressesForSecret(context.Background(), secret)
+	if len(requests) != 1 || requests[0].Namespace != "default" || requests[0].Name != "shop" {
+		t.Errorf("requests = %v, want default/shop", requests)
+	}
+}
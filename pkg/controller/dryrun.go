@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// targetObject is an object of a propagation on the target cluster
+type targetObject struct {
+	kind   string
+	object client.Object
+}
+
+// Diff returns the changes putting the propagation would apply to the target cluster
+func (i *PropagationController) Diff(ctx context.Context, prop propagation.Propagation) ([]diff.Change, error) {
+	desired := desiredObjects(prop)
+
+	var changes []diff.Change
+	for _, obj := range desired {
+		change, err := i.diffObject(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+
+	stale, err := i.staleObjects(ctx, prop)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range stale {
+		changes = append(changes, diff.Change{Action: diff.ActionDelete, Kind: obj.kind, Name: obj.object.GetName()})
+	}
+	return changes, nil
+}
+
+// staleObjects returns the objects of a propagation on the target cluster which are no longer desired, secrets
+// and certificates only if the propagator manages them
+func (i *PropagationController) staleObjects(ctx context.Context, prop propagation.Propagation) ([]targetObject, error) {
+	current, err := i.listTargetObjects(ctx, client.MatchingLabels{LabelManaged: i.Options.Identifier, LabelPropagator: prop.Name}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := desiredObjects(prop)
+	var stale []targetObject
+	for _, obj := range current {
+		managed := obj.kind == "Service" || obj.kind == "Endpoints" ||
+			(obj.kind == "Secret" && i.Options.TLSrespect) ||
+			(obj.kind == CertificateGVK.Kind && i.Options.TargetCertificateMode == CertificateModeResource)
+		if managed && !containsTargetObject(desired, obj) {
+			stale = append(stale, obj)
+		}
+	}
+	return stale, nil
+}
+
+// DiffRemoval returns the changes removing the propagation would apply to the target cluster, objects owned
+// by the target ingress are removed by the garbage collector
+func (i *PropagationController) DiffRemoval(ctx context.Context, prop propagation.Propagation) ([]diff.Change, error) {
+	var changes []diff.Change
+
+	ingress := networkingv1.Ingress{}
+	err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &ingress)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get ingress on target cluster %s: %s", prop.Ingress.Name, err)
+	}
+	changes = append(changes, diff.Change{Action: diff.ActionDelete, Kind: "Ingress", Name: ingress.Name})
+
+	current, err := i.listTargetObjects(ctx, client.MatchingLabels{LabelManaged: i.Options.Identifier, LabelPropagator: prop.Name}, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range current {
+		changes = append(changes, diff.Change{Action: diff.ActionDelete, Kind: obj.kind, Name: obj.object.GetName()})
+	}
+	return changes, nil
+}
+
+// Orphans returns the removal of all objects managed by this propagator on the target cluster, which don't
+// belong to any of the given propagations and aren't retained. Stale objects of the propagations themselves are
+// part of Diff.
+func (i *PropagationController) Orphans(ctx context.Context, props []propagation.Propagation) ([]diff.Change, error) {
+	origins := map[types.NamespacedName]bool{}
+	names := map[string]bool{}
+	ingresses := map[string]bool{}
+	for _, prop := range props {
+		origins[client.ObjectKeyFromObject(&prop.Origin)] = true
+		names[prop.Name] = true
+		ingresses[prop.Ingress.Name] = true
+	}
+
+	current, err := i.listTargetObjects(ctx, client.MatchingLabels{LabelManaged: i.Options.Identifier}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []diff.Change
+	for _, obj := range current {
+		// Retained objects are kept on purpose
+		if obj.object.GetAnnotations()[AnnotationRetained] == "true" {
+			continue
+		}
+		owned := names[obj.object.GetLabels()[LabelPropagator]]
+		// Target ingresses carry the labels of the origin, they reference it by annotation or name instead
+		if obj.kind == "Ingress" {
+			owned = ingresses[obj.object.GetName()]
+			if origin, ok := originOf(obj.object); ok {
+				owned = origins[origin]
+			}
+		}
+		if !owned {
+			changes = append(changes, diff.Change{Action: diff.ActionDelete, Kind: obj.kind, Name: obj.object.GetName()})
+		}
+	}
+	return changes, nil
+}
+
+// diffObject compares a desired object with its current state on the target cluster
+func (i *PropagationController) diffObject(ctx context.Context, obj targetObject) (*diff.Change, error) {
+	current := emptyLike(obj.object)
+	err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(obj.object), current)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return &diff.Change{Action: diff.ActionCreate, Kind: obj.kind, Name: obj.object.GetName()}, nil
+		}
+		return nil, fmt.Errorf("failed to get %s %s in namespace %s: %s", strings.ToLower(obj.kind), obj.object.GetName(), obj.object.GetNamespace(), err)
+	}
+
+	fields, err := diff.Compare(current, obj.object)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return &diff.Change{Action: diff.ActionUpdate, Kind: obj.kind, Name: obj.object.GetName(), Fields: fields}, nil
+}
+
+// listTargetObjects returns the objects with the given labels in the target namespace
+func (i *PropagationController) listTargetObjects(ctx context.Context, selector client.MatchingLabels, ingresses bool) ([]targetObject, error) {
+	opts := []client.ListOption{
+		client.InNamespace(i.Options.TargetNamespace),
+		selector,
+	}
+
+	var objects []targetObject
+	if ingresses {
+		list := networkingv1.IngressList{}
+		if err := i.TargetClient.List(ctx, &list, opts...); err != nil {
+			return nil, fmt.Errorf("failed to list ingresses in namespace %s: %s", i.Options.TargetNamespace, err)
+		}
+		for n := range list.Items {
+			objects = append(objects, targetObject{kind: "Ingress", object: &list.Items[n]})
+		}
+	}
+
+	services := corev1.ServiceList{}
+	if err := i.TargetClient.List(ctx, &services, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list services in namespace %s: %s", i.Options.TargetNamespace, err)
+	}
+	for s := range services.Items {
+		objects = append(objects, targetObject{kind: "Service", object: &services.Items[s]})
+	}
+
+	endpoints := corev1.EndpointsList{}
+	if err := i.TargetClient.List(ctx, &endpoints, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list endpoints in namespace %s: %s", i.Options.TargetNamespace, err)
+	}
+	for e := range endpoints.Items {
+		objects = append(objects, targetObject{kind: "Endpoints", object: &endpoints.Items[e]})
+	}
+
+	// Secrets are only propagated with --tls-respect, listing them requires no access to secrets otherwise
+	if i.Options.TLSrespect {
+		secrets := corev1.SecretList{}
+		if err := i.TargetClient.List(ctx, &secrets, opts...); err != nil {
+			return nil, fmt.Errorf("failed to list secrets in namespace %s: %s", i.Options.TargetNamespace, err)
+		}
+		for s := range secrets.Items {
+			objects = append(objects, targetObject{kind: "Secret", object: &secrets.Items[s]})
+		}
+	}
+
+	if i.Options.TargetCertificateMode == CertificateModeResource {
+		certificates := unstructured.UnstructuredList{}
+		certificates.SetGroupVersionKind(CertificateGVK.GroupVersion().WithKind(CertificateGVK.Kind + "List"))
+		if err := i.TargetClient.List(ctx, &certificates, opts...); err != nil {
+			return nil, fmt.Errorf("failed to list certificates in namespace %s: %s", i.Options.TargetNamespace, err)
+		}
+		for c := range certificates.Items {
+			objects = append(objects, targetObject{kind: CertificateGVK.Kind, object: &certificates.Items[c]})
+		}
+	}
+	return objects, nil
+}
+
+// reportDryRun logs the changes of a dry run and records them as event and metrics instead of applying them
+func (i *PropagationController) reportDryRun(ctx context.Context, prop propagation.Propagation, changes []diff.Change) {
+	log := ctrl.LoggerFrom(ctx)
+	i.Metrics.SetDryRunChanges(client.ObjectKeyFromObject(&prop.Origin), diff.Count(changes))
+	if len(changes) == 0 {
+		log.V(3).Info("dry run: target is up to date")
+		return
+	}
+
+	summaries := make([]string, 0, len(changes))
+	for _, change := range changes {
+		log.Info("dry run: pending change", "action", change.Action, "kind", change.Kind, "name", change.Name, "fields", change.Fields)
+		summaries = append(summaries, fmt.Sprintf("%s %s %s", change.Action, change.Kind, change.Name))
+	}
+	i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "DryRun", "Target changes not applied: %s", strings.Join(summaries, ", "))
+}
+
+// desiredObjects returns all objects of a propagation on the target cluster
+func desiredObjects(prop propagation.Propagation) []targetObject {
+	objects := []targetObject{{kind: "Ingress", object: &prop.Ingress}}
+	for s := range prop.Services {
+		objects = append(objects, targetObject{kind: "Service", object: &prop.Services[s]})
+	}
+	for e := range prop.Endpoints {
+		objects = append(objects, targetObject{kind: "Endpoints", object: &prop.Endpoints[e]})
+	}
+	for s := range prop.Secrets {
+		objects = append(objects, targetObject{kind: "Secret", object: &prop.Secrets[s]})
+	}
+	for c := range prop.Certificates {
+		objects = append(objects, targetObject{kind: CertificateGVK.Kind, object: &prop.Certificates[c]})
+	}
+	return objects
+}
+
+// emptyLike returns an empty object of the same type
+func emptyLike(obj client.Object) client.Object {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		empty := &unstructured.Unstructured{}
+		empty.SetGroupVersionKind(u.GroupVersionKind())
+		return empty
+	}
+	return reflect.New(reflect.TypeOf(obj).Elem()).Interface().(client.Object)
+}
+
+func containsTargetObject(objects []targetObject, obj targetObject) bool {
+	for _, o := range objects {
+		if o.kind == obj.kind && o.object.GetName() == obj.object.GetName() {
+			return true
+		}
+	}
+	return false
+}
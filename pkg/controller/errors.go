@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Reasons of permanent errors
+const (
+	ReasonInvalidIngress = "InvalidIngress"
+	ReasonHostNotAllowed = "HostNotAllowed"
+	ReasonUnknownPort    = "UnknownPort"
+	ReasonInvalidSecret  = "InvalidSecret"
+	ReasonTargetRejected = "TargetRejected"
+)
+
+// PermanentError is an error retrying doesn't resolve, the propagation is retried once the origin ingress changes.
+// All other errors are transient and retried with exponential backoff.
+type PermanentError struct {
+	// Reason in CamelCase, used for conditions and events
+	Reason string
+	Err    error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// permanentf returns a permanent error with a formatted message
+func permanentf(reason string, format string, a ...interface{}) error {
+	return &PermanentError{Reason: reason, Err: fmt.Errorf(format, a...)}
+}
+
+// IsPermanent returns whether retrying the error is pointless, it returns the permanent error if so
+func IsPermanent(err error) (*PermanentError, bool) {
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return permanent, true
+	}
+	return nil, false
+}
+
+// targetErrorf formats an error of the target cluster API, objects rejected as invalid are permanent
+func targetErrorf(err error, format string, a ...interface{}) error {
+	wrapped := fmt.Errorf(format+": %s", append(a, err)...)
+	if k8serrors.IsInvalid(err) {
+		return &PermanentError{Reason: ReasonTargetRejected, Err: wrapped}
+	}
+	return wrapped
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsPermanent(t *testing.T) {
+	invalid := k8serrors.NewInvalid(schema.GroupKind{Group: "networking.k8s.io", Kind: "Ingress"}, "shop", nil)
+	unavailable := k8serrors.NewServiceUnavailable("target unreachable")
+	tests := []struct {
+		name   string
+		err    error
+		want   bool
+		reason string
+	}{
+		{name: "nil"},
+		{name: "transient", err: errors.New("connection refused")},
+		{name: "permanent", err: permanentf(ReasonInvalidIngress, "host is empty"), want: true, reason: ReasonInvalidIngress},
+		{name: "wrapped permanent", err: fmt.Errorf("transform: %w", permanentf(ReasonUnknownPort, "no port http")), want: true, reason: ReasonUnknownPort},
+		{name: "rejected by target", err: targetErrorf(invalid, "failed to create ingress %s", "shop"), want: true, reason: ReasonTargetRejected},
+		{name: "target unavailable", err: targetErrorf(unavailable, "failed to create ingress %s", "shop")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permanent, ok := IsPermanent(tt.err)
+			if ok != tt.want {
+				t.Fatalf("IsPermanent(%v) = %v, want %v", tt.err, ok, tt.want)
+			}
+			if ok && permanent.Reason != tt.reason {
+				t.Errorf("reason = %s, want %s", permanent.Reason, tt.reason)
+			}
+		})
+	}
+}
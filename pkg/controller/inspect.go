@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// States of a propagation on the target cluster
+const (
+	TargetStateHealthy = "Healthy"
+	// The target ingress has no load balancer address yet
+	TargetStatePending = "Pending"
+	TargetStateDrifted = "Drifted"
+	TargetStateMissing = "Missing"
+	// The origin ingress can't be propagated
+	TargetStateFailed = "Failed"
+	// The origin ingress is being deleted
+	TargetStateDeleting = "Deleting"
+)
+
+// States of a single object on the target cluster
+const (
+	ObjectStateOK      = "ok"
+	ObjectStateMissing = "missing"
+	ObjectStateChanged = "changed"
+	ObjectStateExtra   = "extra"
+)
+
+// Inspection describes a propagation and the state of its objects on the target cluster
+type Inspection struct {
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	TargetName      string            `json:"targetName"`
+	TargetNamespace string            `json:"targetNamespace"`
+	Hosts           []string          `json:"hosts"`
+	Addresses       []string          `json:"addresses"`
+	Finalizer       bool              `json:"finalizer"`
+	State           string            `json:"state"`
+	Message         string            `json:"message,omitempty"`
+	Objects         []InspectedObject `json:"objects,omitempty"`
+}
+
+// InspectedObject is an object of a propagation on the target cluster
+type InspectedObject struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Inspect compares a propagation with the target cluster, transformErr is the error of FromIngressToPropagation
+func (i *PropagationController) Inspect(ctx context.Context, prop propagation.Propagation, transformErr error) (Inspection, error) {
+	inspection := Inspection{
+		Namespace:       prop.Origin.Namespace,
+		Name:            prop.Origin.Name,
+		TargetName:      prop.Ingress.Name,
+		TargetNamespace: i.Options.TargetNamespace,
+		Hosts:           ingressHosts(prop.Origin),
+		Addresses:       propagatedAddresses(prop),
+		Finalizer:       controllerutil.ContainsFinalizer(&prop.Origin, IngressControllerFinalizer),
+	}
+	if transformErr != nil {
+		inspection.State = TargetStateFailed
+		inspection.Message = transformErr.Error()
+		return inspection, nil
+	}
+	if prop.IsDeleted {
+		inspection.State = TargetStateDeleting
+		return inspection, nil
+	}
+
+	changes, err := i.Diff(ctx, prop)
+	if err != nil {
+		return inspection, err
+	}
+	states := map[string]string{}
+	for _, change := range changes {
+		states[change.Kind+"/"+change.Name] = map[string]string{
+			diff.ActionCreate: ObjectStateMissing,
+			diff.ActionUpdate: ObjectStateChanged,
+			diff.ActionDelete: ObjectStateExtra,
+		}[change.Action]
+	}
+	for _, obj := range propagatedObjects(prop) {
+		state, ok := states[obj.Kind+"/"+obj.Name]
+		if !ok {
+			state = ObjectStateOK
+		}
+		delete(states, obj.Kind+"/"+obj.Name)
+		inspection.Objects = append(inspection.Objects, InspectedObject{Kind: obj.Kind, Name: obj.Name, State: state})
+	}
+	for _, change := range changes {
+		if _, ok := states[change.Kind+"/"+change.Name]; ok {
+			inspection.Objects = append(inspection.Objects, InspectedObject{Kind: change.Kind, Name: change.Name, State: ObjectStateExtra})
+		}
+	}
+
+	target := networkingv1.Ingress{}
+	err = i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &target)
+	switch {
+	case k8serrors.IsNotFound(err):
+		inspection.State = TargetStateMissing
+	case err != nil:
+		return inspection, fmt.Errorf("failed to get ingress on target cluster %s: %s", prop.Ingress.Name, err)
+	case len(changes) > 0:
+		inspection.State = TargetStateDrifted
+		inspection.Message = fmt.Sprintf("%d objects differ", len(changes))
+	case len(target.Status.LoadBalancer.Ingress) == 0:
+		inspection.State = TargetStatePending
+		inspection.Message = "target ingress has no address"
+	default:
+		inspection.State = TargetStateHealthy
+	}
+	return inspection, nil
+}
+
+// ingressHosts returns the unique hosts of the rules and tls entries of an ingress
+func ingressHosts(ingress networkingv1.Ingress) []string {
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" && !stringSliceContains(hosts, rule.Host) {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			if !stringSliceContains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
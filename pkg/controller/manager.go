@@ -2,18 +2,36 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/logging"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/metrics"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/tracing"
 	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const IngressControllerFinalizer = "svc-ingress-propagator.buttah.cloud/propagated-ingress"
@@ -24,9 +42,21 @@ var _ reconcile.Reconciler = &PropagationController{}
 type PropagationController struct {
 	Client       client.Client
 	TargetClient client.Client
-	Log          logr.Logger
-	Recorder     record.EventRecorder
-	Options      PropagationControllerOptions
+	// Optional cache of the target namespace, required for watches on the target cluster
+	TargetCache cache.Cache
+	Log         logr.Logger
+	Recorder    record.EventRecorder
+	Options     PropagationControllerOptions
+	// Optional health prober for propagated addresses
+	Prober *prober.Prober
+	// Optional metrics recorder
+	Metrics *metrics.Recorder
+	// Optional record of target ingresses to delete once the target is reachable again
+	PendingDeletions *PendingDeletions
+
+	readiness    readinessTracker
+	certificates certificateTracker
+	nodePorts    nodePortTracker
 }
 
 type PropagationControllerOptions struct {
@@ -38,23 +68,132 @@ type PropagationControllerOptions struct {
 	TargetIssuerName       string
 	TargetIssuerNamespaced bool
 	TLSrespect             bool
+	// IP families exported to the target cluster
+	TargetIPFamilies []corev1.IPFamily
+	// Use node addresses and nodeports of the source cluster for services without loadbalancer address
+	NodePortFallback bool
+	NodeSelector     labels.Selector
+	NodeAddressType  corev1.NodeAddressType
+	// Sync certificates issued on the target cluster back to the origin namespace
+	CertificateSync       bool
+	CertificateSyncSuffix string
+	// How certificates are requested on the target cluster
+	TargetCertificateMode   string
+	TargetIssuerKind        string
+	TargetIssuerGroup       string
+	CertificateDuration     time.Duration
+	CertificateKeyAlgorithm string
+	CertificateDNSNames     []string
+	TLSStrategy             string
+	// Wait for the target to converge before reporting a propagation as ready
+	WaitForReadiness  bool
+	ReadinessTimeout  time.Duration
+	ReadinessInterval time.Duration
+	// Report the state of propagations as Propagation resources
+	PropagationStatus bool
+	// Domains hosts must be part of, all hosts are allowed if empty
+	AllowedDomains []string
+	// Report changes to the target cluster instead of applying them
+	DryRun bool
+	// Exponential backoff of transient errors per ingress
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Release deleted origin ingresses whose propagation can't be removed after the timeout, disabled if zero
+	DeletionTimeout time.Duration
+	// What happens to target objects once the origin ingress is deleted, unless overridden by annotation
+	DeletionPolicy string
 }
 
 func (i *PropagationController) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&networkingv1.Ingress{}).
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.Ingress{})
+
+	// Propagate rotated tls secrets, only the metadata of secrets is cached, they are read from the API server
+	if i.Options.TLSrespect {
+		builder = builder.WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(i.ingressesForSecret))
+	}
+
+	// Report the state of certificates on the target cluster
+	if i.Options.TargetCertificateMode == CertificateModeResource && i.TargetCache != nil {
+		certificate := &unstructured.Unstructured{}
+		certificate.SetGroupVersionKind(CertificateGVK)
+		builder = builder.WatchesRawSource(source.Kind(i.TargetCache, certificate), handler.EnqueueRequestsFromMapFunc(i.originForTargetObject))
+	}
+
+	// Sync renewed certificates back from the target cluster
+	if i.Options.CertificateSync && i.TargetCache != nil {
+		builder = builder.WatchesRawSource(source.Kind(i.TargetCache, &corev1.Secret{}), handler.EnqueueRequestsFromMapFunc(i.originsForTargetSecret))
+	}
+
+	// Update the endpoints of the nodeport fallback once nodes are added, removed or readdressed, nodes are cached
+	// anyway as their addresses are listed from the cache
+	if i.Options.NodePortFallback {
+		builder = builder.Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(i.ingressesForNode), ctrlbuilder.WithPredicates(nodeAddressesChanged()))
+	}
+
+	if i.Prober != nil {
+		builder = builder.WatchesRawSource(&source.Channel{Source: i.Prober.Events()}, &handler.EnqueueRequestForObject{})
+	}
+
+	// Replay deletions deferred while the target was unreachable
+	if i.PendingDeletions != nil {
+		err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			wait.UntilWithContext(ctx, func(ctx context.Context) {
+				replayed, err := i.ReplayPendingDeletions(ctx)
+				if replayed > 0 {
+					i.Log.Info("replayed pending deletions", "replayed", replayed)
+				}
+				if err != nil {
+					i.Log.V(1).Info("replay of pending deletions failed", "error", err.Error())
+				}
+			}, i.PendingDeletions.Interval)
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return builder.
+		WithOptions(crcontroller.Options{
+			RateLimiter: workqueue.NewMaxOfRateLimiter(
+				workqueue.NewItemExponentialFailureRateLimiter(i.Options.BackoffBase, i.Options.BackoffMax),
+				// Overall limit of the controller-runtime default
+				&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+			),
+		}).
+		WithLogConstructor(i.logFor).
 		Complete(i)
 }
 
-func (i *PropagationController) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
-	log := i.Log.WithValues("ingress", request.NamespacedName)
-	//reconcileStart := time.Now()
-	//reconciliationLoopID := uuid.New().String()
-	//log := ctrl.LoggerFrom(ctx, "reconciliation-loop-id", reconciliationLoopID, "start-time", reconcileStart)
+// logFor returns the logger of a reconcile request with the keys shared by all controller logs, the
+// controller adds the reconcile id and passes the logger on through the context
+func (i *PropagationController) logFor(request *reconcile.Request) logr.Logger {
+	log := i.Log.WithValues(
+		logging.KeyIdentifier, i.Options.Identifier,
+		logging.KeyTarget, i.Options.TargetNamespace,
+	)
+	if request != nil {
+		log = log.WithValues(
+			logging.KeyIngress, request.Name,
+			logging.KeyNamespace, request.Namespace,
+		)
+	}
+	return log
+}
 
-	i.Log.V(3).Info("Reconciling",
-		"ingress", request.NamespacedName,
+func (i *PropagationController) Reconcile(ctx context.Context, request ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "Reconcile",
+		tracing.AttributeIngress.String(request.String()),
+		tracing.AttributeIdentifier.String(i.Options.Identifier),
+		tracing.AttributeTargetNamespace.String(i.Options.TargetNamespace),
 	)
+	defer func() { tracing.End(span, err) }()
+
+	// Carries the shared keys of logFor and the reconcile id
+	log := ctrl.LoggerFrom(ctx)
+
+	log.V(3).Info("Reconciling")
 
 	log.V(5).Info("Fetch Ingress Resource")
 	var origin networkingv1.Ingress
@@ -65,40 +204,80 @@ func (i *PropagationController) Reconcile(ctx context.Context, request ctrl.Requ
 
 	controlled, err := i.isControlledByThisController(ctx, origin)
 	if err != nil && !apierrors.IsNotFound(err) {
-		i.Log.V(3).Error(err, "check if ingress %s is controlled by this controller", request.NamespacedName)
-		return reconcile.Result{
-			RequeueAfter: time.Second * 60,
-		}, nil
+		return ctrl.Result{}, fmt.Errorf("check if ingress is controlled by this controller: %s", err)
 	}
 
 	if !controlled {
-		i.Log.V(5).Info("ingress is NOT controlled by this controller",
-			"ingress", request.NamespacedName,
-			"controlled-ingress-class", i.Options.IngressClassName,
-			"controlled-controller-class", i.Options.ControllerClassName,
+		log.V(5).Info("ingress is NOT controlled by this controller",
+			"ingressClass", i.Options.IngressClassName,
+			"controllerClass", i.Options.ControllerClassName,
 		)
 		return reconcile.Result{
 			Requeue: false,
 		}, nil
 	}
 
-	i.Log.V(5).Info("update propagations", "triggered-by", request.NamespacedName)
-	propagation, err := i.FromIngressToPropagation(ctx, i.Log, i.Client, origin)
+	i.Metrics.Observe(request.NamespacedName, origin.Generation)
+
+	// Conditions are collected and written once. The status only reports the propagation, a failed write (eg. a
+	// missing CRD after upgrading the chart) doesn't fail the reconciliation
+	report := &statusReport{}
+	defer func() {
+		if statusErr := i.writeStatus(ctx, report); statusErr != nil {
+			log.Error(statusErr, "failed to write propagation status")
+			i.Recorder.Eventf(&origin, corev1.EventTypeWarning, "StatusFailed", "failed to write propagation status: %s", statusErr)
+		}
+	}()
+
+	log.V(5).Info("update propagations")
+	propagation, err := i.FromIngressToPropagation(ctx, i.Client, origin)
+	i.nodePorts.track(request.NamespacedName, i.Options.NodePortFallback && len(propagation.BackendsWithoutAddress) > 0)
 	if err != nil {
+		reason := "TransformFailed"
+		permanent, isPermanent := IsPermanent(err)
+		if isPermanent {
+			reason = permanent.Reason
+		}
 		i.Recorder.Eventf(&origin, corev1.EventTypeWarning, "PropagationFailed", "failed to extract propagations from ingress: %s", err.Error())
+		i.Metrics.SetBackendsWithoutAddress(request.NamespacedName, len(propagation.BackendsWithoutAddress))
+		i.Metrics.SetState(request.NamespacedName, metrics.StateFailed)
+		report.set(propagation,
+			condition(v1alpha1.ConditionSynced, metav1.ConditionFalse, reason, err.Error()),
+			condition(v1alpha1.ConditionReady, metav1.ConditionFalse, reason, err.Error()),
+			terminal(err),
+		)
 
-		return reconcile.Result{
-			RequeueAfter: time.Second * 60,
-		}, nil
+		if isPermanent {
+			log.Info("Propagation failed permanently, waiting for the ingress to change", "reason", reason, "error", err.Error())
+			return ctrl.Result{}, nil
+		}
+		// Retried with exponential backoff, without logging the error again
+		return ctrl.Result{Requeue: true}, nil
 	}
 
-	i.Log.V(5).Info("all propagations", "propagations", propagation.Ingress)
+	log.V(5).Info("all propagations", "propagations", propagation.Ingress)
 	if !origin.ObjectMeta.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(&origin, IngressControllerFinalizer) {
 			err := i.removePropagation(ctx, propagation)
 			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("delete propagations %s", err)
+				if i.Options.DryRun || !i.releasable(origin) {
+					return ctrl.Result{}, fmt.Errorf("delete propagations %s", err)
+				}
+				if err := i.deferRemoval(ctx, propagation, err); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			if i.Prober != nil {
+				i.Prober.Unregister(origin)
 			}
+			i.readiness.forget(origin)
+			i.certificates.forget(origin)
+			i.nodePorts.track(request.NamespacedName, false)
+			// The finalizer is left to the propagator applying the changes
+			if i.Options.DryRun {
+				return ctrl.Result{}, nil
+			}
+			i.Metrics.Forget(request.NamespacedName)
 			controllerutil.RemoveFinalizer(&origin, IngressControllerFinalizer)
 			if err := i.Client.Update(ctx, &origin); err != nil {
 				return ctrl.Result{}, err
@@ -110,8 +289,69 @@ func (i *PropagationController) Reconcile(ctx context.Context, request ctrl.Requ
 	} else {
 		err := i.putPropagation(ctx, propagation)
 		if err != nil {
+			var conflict *ConflictError
+			if errors.As(err, &conflict) {
+				i.Recorder.Eventf(&origin, corev1.EventTypeWarning, "PropagationConflict", "Propagation conflicts with target cluster: %s", err.Error())
+				i.Metrics.Conflict(request.NamespacedName)
+				report.set(propagation,
+					condition(v1alpha1.ConditionConflict, metav1.ConditionTrue, "Conflict", err.Error()),
+					condition(v1alpha1.ConditionSynced, metav1.ConditionFalse, "Conflict", err.Error()),
+					condition(v1alpha1.ConditionReady, metav1.ConditionFalse, "Conflict", err.Error()),
+					terminal(err),
+				)
+				// The other owner may go away, retried with backoff
+				return ctrl.Result{Requeue: true}, nil
+			}
+			i.Metrics.SetState(request.NamespacedName, metrics.StateFailed)
+			report.set(propagation,
+				condition(v1alpha1.ConditionSynced, metav1.ConditionFalse, "TargetWriteFailed", err.Error()),
+				condition(v1alpha1.ConditionReady, metav1.ConditionFalse, "TargetWriteFailed", err.Error()),
+				terminal(err),
+			)
+			if permanent, ok := IsPermanent(err); ok {
+				i.Recorder.Eventf(&origin, corev1.EventTypeWarning, "PropagationFailed", "Target cluster rejected the propagation: %s", err.Error())
+				log.Info("Propagation failed permanently, waiting for the ingress to change", "reason", permanent.Reason, "error", err.Error())
+				return ctrl.Result{}, nil
+			}
 			return ctrl.Result{}, fmt.Errorf("update propagations %s", err)
 		}
+		if i.Options.DryRun {
+			if i.Prober != nil {
+				i.Prober.Register(origin, probeTargets(propagation))
+			}
+			// Nothing was written, the source cluster is left untouched as well
+			return ctrl.Result{}, nil
+		}
+		i.Metrics.Synced(request.NamespacedName, len(propagation.Services), len(propagation.Endpoints), len(propagation.BackendsWithoutAddress))
+		i.Metrics.SetState(request.NamespacedName, metrics.StateSynced)
+		report.set(propagation,
+			condition(v1alpha1.ConditionConflict, metav1.ConditionFalse, "NoConflict", ""),
+			condition(v1alpha1.ConditionSynced, metav1.ConditionTrue, "Synced", "All objects written to target cluster"),
+			terminal(nil),
+		)
+		if i.Prober != nil {
+			i.Prober.Register(origin, probeTargets(propagation))
+		}
+		if len(propagation.Certificates) > 0 {
+			if err := i.reportCertificates(ctx, propagation); err != nil {
+				return ctrl.Result{}, fmt.Errorf("report certificates %s", err)
+			}
+		}
+		if i.Options.CertificateSync {
+			if err := i.syncCertificates(ctx, propagation); err != nil {
+				var conflict *ConflictError
+				if errors.As(err, &conflict) {
+					i.Recorder.Eventf(&origin, corev1.EventTypeWarning, "CertificateConflict", "Certificate can't be synced: %s", err.Error())
+					i.Metrics.Conflict(request.NamespacedName)
+					report.set(propagation,
+						condition(v1alpha1.ConditionConflict, metav1.ConditionTrue, "SecretConflict", err.Error()),
+					)
+					// The secret may be removed or handed over, retried with backoff
+					return ctrl.Result{Requeue: true}, nil
+				}
+				return ctrl.Result{}, fmt.Errorf("sync certificates %s", err)
+			}
+		}
 		if !controllerutil.ContainsFinalizer(&origin, IngressControllerFinalizer) {
 			controllerutil.AddFinalizer(&origin, IngressControllerFinalizer)
 			if err := i.Client.Update(ctx, &origin); err != nil {
@@ -120,6 +360,22 @@ func (i *PropagationController) Reconcile(ctx context.Context, request ctrl.Requ
 		}
 	}
 
-	i.Log.V(3).Info("Reconcile completed")
+	if i.Options.WaitForReadiness {
+		requeue, err := i.waitForReadiness(ctx, propagation, report)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("check readiness %s", err)
+		}
+		if requeue > 0 {
+			log.V(3).Info("Waiting for propagation to become ready", "requeueAfter", requeue)
+			return ctrl.Result{RequeueAfter: requeue}, nil
+		}
+	}
+
+	if !i.Options.WaitForReadiness {
+		i.Recorder.Eventf(&origin, corev1.EventTypeNormal, "IngressPropagated", "Ingress has been propagated")
+		report.set(propagation, condition(v1alpha1.ConditionReady, metav1.ConditionTrue, "Synced", "All objects written to target cluster"))
+	}
+
+	log.V(3).Info("Reconcile completed")
 	return ctrl.Result{}, nil
 }
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestReconcileDryRun(t *testing.T) {
+	controlled := func(ingress networkingv1.Ingress) *networkingv1.Ingress {
+		ingress.Annotations = map[string]string{WellKnownIngressAnnotation: "propagator"}
+		return &ingress
+	}
+	deleted := controlled(testIngress("blog", "blog.example.com"))
+	deleted.Finalizers = []string{IngressControllerFinalizer}
+	deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	i := testController(PropagationControllerOptions{
+		IngressClassName: "propagator",
+		TargetIPFamilies: []corev1.IPFamily{corev1.IPv4Protocol},
+		DryRun:           true,
+	}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+		controlled(testIngress("shop", "shop.example.com")),
+		deleted,
+	}, nil)
+	recorder := i.Recorder.(*record.FakeRecorder)
+	ctx := context.Background()
+
+	for _, name := range []string{"shop", "blog"} {
+		if _, err := i.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: name}}); err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", name, err)
+		}
+	}
+
+	// Dry runs never add the finalizer, the finalizer of a propagator applying changes is left to it
+	for name, want := range map[string]bool{"shop": false, "blog": true} {
+		ingress := networkingv1.Ingress{}
+		if err := i.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &ingress); err != nil {
+			t.Fatal(err)
+		}
+		if got := controllerutil.ContainsFinalizer(&ingress, IngressControllerFinalizer); got != want {
+			t.Errorf("ingress %s has finalizer = %v, want %v", name, got, want)
+		}
+	}
+	ingresses := networkingv1.IngressList{}
+	if err := i.TargetClient.List(ctx, &ingresses); err != nil {
+		t.Fatal(err)
+	}
+	if len(ingresses.Items) != 0 {
+		t.Errorf("dry run wrote %d ingresses to the target cluster", len(ingresses.Items))
+	}
+	if len(recorder.Events) == 0 {
+		t.Errorf("dry run reported no changes")
+	}
+}
+
+func TestReconcileStatusFailure(t *testing.T) {
+	origin := testIngress("shop", "shop.example.com")
+	origin.Annotations = map[string]string{WellKnownIngressAnnotation: "propagator"}
+	i := testController(PropagationControllerOptions{
+		IngressClassName:  "propagator",
+		TargetIPFamilies:  []corev1.IPFamily{corev1.IPv4Protocol},
+		PropagationStatus: true,
+	}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+		&origin,
+	}, nil)
+	// The Propagation CRD is missing, eg. after upgrading the chart
+	i.Client = interceptor.NewClient(i.Client.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if _, ok := obj.(*v1alpha1.Propagation); ok {
+				return &meta.NoKindMatchError{GroupKind: v1alpha1.GroupVersion.WithKind("Propagation").GroupKind()}
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+	recorder := i.Recorder.(*record.FakeRecorder)
+	ctx := context.Background()
+
+	if _, err := i.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&origin)}); err != nil {
+		t.Fatalf("Reconcile() error = %v, want the propagation to succeed", err)
+	}
+	ingress := networkingv1.Ingress{}
+	if err := i.TargetClient.Get(ctx, client.ObjectKey{Namespace: "target", Name: "id-shop"}, &ingress); err != nil {
+		t.Fatalf("ingress was not propagated: %s", err)
+	}
+	var failed bool
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, "StatusFailed") {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Errorf("failed status write was not reported")
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// nodePortTracker tracks the origins propagated with the node addresses of the nodeport fallback
+type nodePortTracker struct {
+	mu      sync.Mutex
+	origins map[types.NamespacedName]bool
+}
+
+// track records whether an origin uses the nodeport fallback
+func (t *nodePortTracker) track(origin types.NamespacedName, fallback bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !fallback {
+		delete(t.origins, origin)
+		return
+	}
+	if t.origins == nil {
+		t.origins = map[types.NamespacedName]bool{}
+	}
+	t.origins[origin] = true
+}
+
+func (t *nodePortTracker) requests() []reconcile.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests := make([]reconcile.Request, 0, len(t.origins))
+	for origin := range t.origins {
+		requests = append(requests, reconcile.Request{NamespacedName: origin})
+	}
+	return requests
+}
+
+// ingressesForNode enqueues the ingresses using the nodeport fallback, their endpoints follow the node addresses
+func (i *PropagationController) ingressesForNode(ctx context.Context, node client.Object) []reconcile.Request {
+	return i.nodePorts.requests()
+}
+
+// nodeAddressesChanged filters node updates not affecting the propagated addresses, eg. status heartbeats
+func nodeAddressesChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			updated, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return true
+			}
+			return isNodeReady(*old) != isNodeReady(*updated) ||
+				!equality.Semantic.DeepEqual(old.Labels, updated.Labels) ||
+				!equality.Semantic.DeepEqual(old.Status.Addresses, updated.Status.Addresses)
+		},
+	}
+}
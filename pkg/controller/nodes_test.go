@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNodePortTracker(t *testing.T) {
+	shop := types.NamespacedName{Namespace: "default", Name: "shop"}
+	blog := types.NamespacedName{Namespace: "default", Name: "blog"}
+
+	tracker := nodePortTracker{}
+	tracker.track(shop, true)
+	tracker.track(blog, false)
+	if got := tracker.requests(); len(got) != 1 || got[0].NamespacedName != shop {
+		t.Errorf("requests() = %v, want [%s]", got, shop)
+	}
+	// The backend got a loadbalancer address
+	tracker.track(shop, false)
+	if got := tracker.requests(); len(got) != 0 {
+		t.Errorf("requests() = %v, want none", got)
+	}
+}
+
+func TestNodeAddressesChanged(t *testing.T) {
+	node := func(ready corev1.ConditionStatus, address string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready, LastHeartbeatTime: metav1.Now()}},
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}},
+			},
+		}
+	}
+	current := node(corev1.ConditionTrue, "10.0.0.1", map[string]string{"pool": "edge"})
+
+	tests := []struct {
+		name    string
+		updated *corev1.Node
+		want    bool
+	}{
+		{name: "heartbeat", updated: node(corev1.ConditionTrue, "10.0.0.1", map[string]string{"pool": "edge"})},
+		{name: "not ready", updated: node(corev1.ConditionFalse, "10.0.0.1", map[string]string{"pool": "edge"}), want: true},
+		{name: "readdressed", updated: node(corev1.ConditionTrue, "10.0.0.2", map[string]string{"pool": "edge"}), want: true},
+		{name: "relabelled", updated: node(corev1.ConditionTrue, "10.0.0.1", map[string]string{"pool": "core"}), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeAddressesChanged().Update(event.UpdateEvent{ObjectOld: current, ObjectNew: tt.updated}); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationForceRemoveFinalizer releases a deleted origin ingress right away if its propagation can't be removed
+var AnnotationForceRemoveFinalizer = MetaBase + "/force-remove-finalizer"
+
+// PendingDeletions persists target ingresses of origin ingresses released before their propagation was removed,
+// they are removed once the target cluster is reachable again. Entries map the target ingress name to the removal.
+type PendingDeletions struct {
+	// Uncached client of the source cluster, config maps aren't watched
+	Client client.Client
+	// ConfigMap holding the pending deletions
+	Key types.NamespacedName
+	// Interval between replays of the pending deletions
+	Interval time.Duration
+}
+
+// PendingDeletion is the deferred removal of a target ingress
+type PendingDeletion struct {
+	// Origin ingress (namespace/name)
+	Origin string `json:"origin"`
+	// Deletion policy of the origin ingress
+	Policy string `json:"policy,omitempty"`
+}
+
+// List returns the pending deletions, there are none if the config map doesn't exist
+func (p *PendingDeletions) List(ctx context.Context) (map[string]PendingDeletion, error) {
+	configMap := &corev1.ConfigMap{}
+	err := p.Client.Get(ctx, p.Key, configMap)
+	if k8serrors.IsNotFound(err) {
+		return map[string]PendingDeletion{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending deletions %s: %s", p.Key, err)
+	}
+
+	pending := make(map[string]PendingDeletion, len(configMap.Data))
+	for name, value := range configMap.Data {
+		deletion := PendingDeletion{}
+		if err := json.Unmarshal([]byte(value), &deletion); err != nil {
+			// Entries edited by hand may only reference the origin, they are deleted
+			deletion = PendingDeletion{Origin: value}
+		}
+		pending[name] = deletion
+	}
+	return pending, nil
+}
+
+// Add records the removal of a target ingress
+func (p *PendingDeletions) Add(ctx context.Context, name string, deletion PendingDeletion) error {
+	value, err := json.Marshal(deletion)
+	if err != nil {
+		return err
+	}
+	return p.update(ctx, func(data map[string]string) {
+		data[name] = string(value)
+	})
+}
+
+// Remove drops the deletions of target ingresses
+func (p *PendingDeletions) Remove(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return p.update(ctx, func(data map[string]string) {
+		for _, name := range names {
+			delete(data, name)
+		}
+	})
+}
+
+func (p *PendingDeletions) update(ctx context.Context, mutate func(data map[string]string)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := p.Client.Get(ctx, p.Key, configMap)
+		if k8serrors.IsNotFound(err) {
+			configMap.Name, configMap.Namespace = p.Key.Name, p.Key.Namespace
+			configMap.Data = map[string]string{}
+			mutate(configMap.Data)
+			if len(configMap.Data) == 0 {
+				return nil
+			}
+			return p.Client.Create(ctx, configMap)
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		mutate(configMap.Data)
+		return p.Client.Update(ctx, configMap)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update pending deletions %s: %s", p.Key, err)
+	}
+	return nil
+}
+
+// releasable returns whether a deleted origin ingress is released although its propagation can't be removed
+func (i *PropagationController) releasable(origin networkingv1.Ingress) bool {
+	if origin.Annotations[AnnotationForceRemoveFinalizer] == "true" {
+		return true
+	}
+	return i.Options.DeletionTimeout > 0 && time.Since(origin.DeletionTimestamp.Time) >= i.Options.DeletionTimeout
+}
+
+// deferRemoval records the removal of a propagation as pending, the origin ingress is released afterwards
+func (i *PropagationController) deferRemoval(ctx context.Context, prop propagation.Propagation, cause error) error {
+	if i.PendingDeletions == nil {
+		ctrl.LoggerFrom(ctx).Info("Releasing ingress, its propagation is left on the target cluster", "error", cause.Error())
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeWarning, "RemovalSkipped", "Ingress released, ingress %s is left on the target cluster until pruned: %s", prop.Ingress.Name, cause)
+		return nil
+	}
+	deletion := PendingDeletion{
+		Origin: fmt.Sprintf("%s/%s", prop.Origin.Namespace, prop.Origin.Name),
+		Policy: i.deletionPolicy(prop.Origin),
+	}
+	if err := i.PendingDeletions.Add(ctx, prop.Ingress.Name, deletion); err != nil {
+		return fmt.Errorf("record pending deletion %s", err)
+	}
+	ctrl.LoggerFrom(ctx).Info("Releasing ingress, removal of the propagation is deferred", "error", cause.Error())
+	i.Recorder.Eventf(&prop.Origin, corev1.EventTypeWarning, "RemovalDeferred", "Ingress released, removal of ingress %s from the target cluster is deferred: %s", prop.Ingress.Name, cause)
+	return nil
+}
+
+// ReplayPendingDeletions removes the target ingresses of released origin ingresses according to their deletion policy
+// and returns the number of replayed entries. Entries are kept until the removal succeeded, entries of origins
+// propagated again are dropped. Failing entries don't hold back the others, their errors are aggregated.
+func (i *PropagationController) ReplayPendingDeletions(ctx context.Context) (int, error) {
+	pending, err := i.PendingDeletions.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var done []string
+	var errs []error
+	for name, deletion := range pending {
+		if err := i.replayDeletion(ctx, name, deletion); err != nil {
+			errs = append(errs, fmt.Errorf("replay deletion of %s: %s", name, err))
+			continue
+		}
+		done = append(done, name)
+	}
+	if err := i.PendingDeletions.Remove(ctx, done...); err != nil {
+		return 0, err
+	}
+	return len(done), utilerrors.NewAggregate(errs)
+}
+
+// replayDeletion removes a target ingress unless its origin ingress exists again
+func (i *PropagationController) replayDeletion(ctx context.Context, name string, deletion PendingDeletion) error {
+	namespace, originName, _ := strings.Cut(deletion.Origin, "/")
+	var ingress networkingv1.Ingress
+	err := i.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: originName}, &ingress)
+	if err == nil && ingress.DeletionTimestamp.IsZero() {
+		// The origin was created again and owns the target ingress
+		return nil
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get ingress %s: %s", deletion.Origin, err)
+	}
+
+	// Target objects reference the origin by name
+	prop := propagation.Propagation{
+		Name:    originName,
+		Ingress: networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: i.Options.TargetNamespace}},
+	}
+	return i.removeTarget(ctx, prop, deletion.Policy)
+}
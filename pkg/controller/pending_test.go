@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var pendingKey = types.NamespacedName{Namespace: "propagator", Name: "pending-deletions"}
+
+func TestPendingDeletionsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pending := &PendingDeletions{Client: fake.NewClientBuilder().WithScheme(testScheme).Build(), Key: pendingKey}
+
+	// Without config map there is nothing pending, removing nothing doesn't create it
+	list, err := pending.List(ctx)
+	if err != nil || len(list) != 0 {
+		t.Fatalf("List() = %v, %v, want no deletions", list, err)
+	}
+	if err := pending.Remove(ctx, "id-shop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.Client.Get(ctx, pendingKey, &corev1.ConfigMap{}); err == nil {
+		t.Errorf("config map created without deletions")
+	}
+
+	shop := PendingDeletion{Origin: "default/shop", Policy: DeletionPolicyOrphan}
+	blog := PendingDeletion{Origin: "default/blog"}
+	if err := pending.Add(ctx, "id-shop", shop); err != nil {
+		t.Fatal(err)
+	}
+	if err := pending.Add(ctx, "id-blog", blog); err != nil {
+		t.Fatal(err)
+	}
+	list, err = pending.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]PendingDeletion{"id-shop": shop, "id-blog": blog}; !reflect.DeepEqual(list, want) {
+		t.Errorf("List() = %v, want %v", list, want)
+	}
+
+	if err := pending.Remove(ctx, "id-shop"); err != nil {
+		t.Fatal(err)
+	}
+	list, err = pending.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]PendingDeletion{"id-blog": blog}; !reflect.DeepEqual(list, want) {
+		t.Errorf("List() after Remove() = %v, want %v", list, want)
+	}
+}
+
+func TestPendingDeletionsHandEdited(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: pendingKey.Name, Namespace: pendingKey.Namespace},
+		Data:       map[string]string{"id-shop": "default/shop"},
+	}
+	pending := &PendingDeletions{Client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(configMap).Build(), Key: pendingKey}
+	list, err := pending.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PendingDeletion{Origin: "default/shop"}); list["id-shop"] != want {
+		t.Errorf("List() = %v, want %v", list["id-shop"], want)
+	}
+}
+
+func TestReplayPendingDeletions(t *testing.T) {
+	targetIngress := func(name string, origin string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "target",
+			Labels:      map[string]string{LabelManaged: "id"},
+			Annotations: map[string]string{AnnotationOrigin: origin},
+		}}
+	}
+	i := testController(PropagationControllerOptions{}, []client.Object{
+		// Created again, it owns its target ingress
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "blog", Namespace: "default"}},
+	}, nil)
+	i.TargetClient = interceptor.NewClient(fake.NewClientBuilder().WithScheme(testScheme).WithObjects(
+		targetIngress("id-shop", "default/shop"),
+		targetIngress("id-blog", "default/blog"),
+		targetIngress("id-broken", "default/broken"),
+	).Build(), interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if obj.GetName() == "id-broken" {
+				return errors.New("admission webhook denied the request")
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})
+	i.PendingDeletions = &PendingDeletions{Client: i.Client, Key: pendingKey}
+
+	ctx := context.Background()
+	// Entries are replayed in random order, the failing one must not hold back the others
+	for name, origin := range map[string]string{"id-broken": "default/broken", "id-shop": "default/shop", "id-blog": "default/blog"} {
+		if err := i.PendingDeletions.Add(ctx, name, PendingDeletion{Origin: origin}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replayed, err := i.ReplayPendingDeletions(ctx)
+	if err == nil {
+		t.Errorf("ReplayPendingDeletions() returned no error of the failing entry")
+	}
+	if replayed != 2 {
+		t.Errorf("replayed = %d, want 2", replayed)
+	}
+
+	list, err := i.PendingDeletions.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := list["id-broken"]; len(list) != 1 || !ok {
+		t.Errorf("pending deletions = %v, want only id-broken", list)
+	}
+	for name, exists := range map[string]bool{"id-shop": false, "id-blog": true, "id-broken": true} {
+		err := i.TargetClient.Get(ctx, client.ObjectKey{Namespace: "target", Name: name}, &networkingv1.Ingress{})
+		if (err == nil) != exists {
+			t.Errorf("target ingress %s exists = %v, want %v", name, err == nil, exists)
+		}
+	}
+}
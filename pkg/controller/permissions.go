@@ -0,0 +1,65 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// TargetPermission are the verbs the propagator requires on a resource of the target cluster
+type TargetPermission struct {
+	Group    string
+	Resource string
+	Verbs    []string
+	// Limits the permission to the named objects
+	ResourceNames []string
+	// Cluster scoped permissions are granted by a ClusterRole
+	ClusterScoped bool
+	// Only required by preflight checks, missing it is no failure
+	Preflight bool
+}
+
+// RequiredTargetPermissions returns the permissions required on the target cluster with the given options, all but
+// the cluster scoped ones are limited to the target namespace
+func RequiredTargetPermissions(options PropagationControllerOptions) []TargetPermission {
+	write := []string{"get", "list", "create", "update", "delete"}
+	permissions := []TargetPermission{
+		{Group: networkingv1.GroupName, Resource: "ingresses", Verbs: write},
+		{Group: corev1.GroupName, Resource: "services", Verbs: write},
+		{Group: corev1.GroupName, Resource: "endpoints", Verbs: write},
+	}
+
+	// Secrets are propagated with --tls-respect, issued certificates are watched with --certificate-sync
+	var secrets []string
+	if options.TLSrespect {
+		secrets = append(secrets, write...)
+	}
+	if options.CertificateSync {
+		if !options.TLSrespect {
+			secrets = append(secrets, "get", "list")
+		}
+		secrets = append(secrets, "watch")
+	}
+	if len(secrets) > 0 {
+		permissions = append(permissions, TargetPermission{Group: corev1.GroupName, Resource: "secrets", Verbs: secrets})
+	}
+
+	switch {
+	case options.TargetCertificateMode == CertificateModeResource:
+		permissions = append(permissions, TargetPermission{Group: CertificateGVK.Group, Resource: "certificates", Verbs: append(write, "watch")})
+	case options.TargetIssuerName != "":
+		// Certificates of ingress-shim are read for the propagation status and readiness
+		permissions = append(permissions, TargetPermission{Group: CertificateGVK.Group, Resource: "certificates", Verbs: []string{"get", "list"}})
+	}
+
+	permissions = append(permissions, TargetPermission{Group: corev1.GroupName, Resource: "namespaces", Verbs: []string{"get"}, ResourceNames: []string{options.TargetNamespace}, ClusterScoped: true, Preflight: true})
+	permissions = append(permissions, TargetPermission{Group: networkingv1.GroupName, Resource: "ingressclasses", Verbs: []string{"get"}, ResourceNames: []string{options.TargetIngressClassName}, ClusterScoped: true, Preflight: true})
+	if options.TargetIssuerName != "" {
+		switch kind, group := options.issuerKind(); {
+		case group == CertificateGVK.Group && kind == "Issuer":
+			permissions = append(permissions, TargetPermission{Group: group, Resource: "issuers", Verbs: []string{"get"}, ResourceNames: []string{options.TargetIssuerName}, Preflight: true})
+		case group == CertificateGVK.Group && kind == "ClusterIssuer":
+			permissions = append(permissions, TargetPermission{Group: group, Resource: "clusterissuers", Verbs: []string{"get"}, ResourceNames: []string{options.TargetIssuerName}, ClusterScoped: true, Preflight: true})
+		}
+	}
+	return permissions
+}
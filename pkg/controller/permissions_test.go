@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequiredTargetPermissionsSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PropagationControllerOptions
+		want    []string
+	}{
+		{name: "plain propagation"},
+		{name: "tls respect", options: PropagationControllerOptions{TLSrespect: true}, want: []string{"get", "list", "create", "update", "delete"}},
+		{name: "certificate sync", options: PropagationControllerOptions{CertificateSync: true}, want: []string{"get", "list", "watch"}},
+		{name: "both", options: PropagationControllerOptions{TLSrespect: true, CertificateSync: true}, want: []string{"get", "list", "create", "update", "delete", "watch"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, permission := range RequiredTargetPermissions(tt.options) {
+				if permission.Resource == "secrets" {
+					got = permission.Verbs
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("secret verbs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
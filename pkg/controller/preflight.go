@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Results of preflight checks, the propagator refuses to start on failures
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// CheckResult is the result of a single preflight check
+type CheckResult struct {
+	Name    string
+	Status  string
+	Message string
+}
+
+// PreflightFailed returns whether any of the checks failed
+func PreflightFailed(results []CheckResult) bool {
+	for _, result := range results {
+		if result.Status == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight verifies the target cluster can be used with the configured options: the permissions of the target
+// client, and the existence of the target namespace, ingress class, issuer and certificate resource
+func (i *PropagationController) Preflight(ctx context.Context) []CheckResult {
+	var results []CheckResult
+	for _, permission := range RequiredTargetPermissions(i.Options) {
+		results = append(results, i.checkPermission(ctx, permission))
+	}
+
+	results = append(results, i.checkExists(ctx, "target namespace", &corev1.Namespace{}, client.ObjectKey{Name: i.Options.TargetNamespace}))
+	results = append(results, i.checkExists(ctx, "target ingress class", &networkingv1.IngressClass{}, client.ObjectKey{Name: i.Options.TargetIngressClassName}))
+
+	if i.Options.TargetIssuerName != "" {
+		kind, group := i.Options.issuerKind()
+		name := fmt.Sprintf("target %s", strings.ToLower(kind))
+		if group != CertificateGVK.Group || (kind != "Issuer" && kind != "ClusterIssuer") {
+			results = append(results, CheckResult{Name: name, Status: CheckWarn, Message: fmt.Sprintf("external issuer %s.%s %s is not verified", kind, group, i.Options.TargetIssuerName)})
+		} else {
+			issuer := &unstructured.Unstructured{}
+			issuer.SetGroupVersionKind(CertificateGVK.GroupVersion().WithKind(kind))
+			key := client.ObjectKey{Name: i.Options.TargetIssuerName}
+			if kind == "Issuer" {
+				key.Namespace = i.Options.TargetNamespace
+			}
+			results = append(results, i.checkExists(ctx, name, issuer, key))
+		}
+	}
+
+	if i.Options.TargetCertificateMode == CertificateModeResource {
+		result := CheckResult{Name: "target certificate resource", Status: CheckPass}
+		certificates := unstructured.UnstructuredList{}
+		certificates.SetGroupVersionKind(CertificateGVK.GroupVersion().WithKind(CertificateGVK.Kind + "List"))
+		err := i.TargetClient.List(ctx, &certificates, client.InNamespace(i.Options.TargetNamespace), client.Limit(1))
+		if meta.IsNoMatchError(err) {
+			result.Status, result.Message = CheckFail, fmt.Sprintf("%s is not installed on the target cluster", CertificateGVK.GroupKind())
+		} else if err != nil {
+			result.Status, result.Message = CheckWarn, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkPermission reviews all verbs of a permission, missing permissions of preflight checks are no failure,
+// neither are missing permissions to write in a dry run
+func (i *PropagationController) checkPermission(ctx context.Context, permission TargetPermission) CheckResult {
+	resource := schema.GroupResource{Group: permission.Group, Resource: permission.Resource}.String()
+	result := CheckResult{Name: fmt.Sprintf("permissions on %s", resource), Status: CheckPass}
+
+	var denied []string
+	hard := false
+	for _, verb := range permission.Verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:    permission.Group,
+					Resource: permission.Resource,
+					Verb:     verb,
+				},
+			},
+		}
+		if !permission.ClusterScoped {
+			review.Spec.ResourceAttributes.Namespace = i.Options.TargetNamespace
+		}
+		if len(permission.ResourceNames) == 1 {
+			review.Spec.ResourceAttributes.Name = permission.ResourceNames[0]
+		}
+		if err := i.TargetClient.Create(ctx, review); err != nil {
+			// Only denied verbs are definitive, an unreachable target is reported by readiness
+			result.Status, result.Message = CheckWarn, fmt.Sprintf("failed to review access: %s", err)
+			return result
+		}
+		if !review.Status.Allowed {
+			denied = append(denied, verb)
+			read := verb == "get" || verb == "list" || verb == "watch"
+			hard = hard || (!permission.Preflight && (read || !i.Options.DryRun))
+		}
+	}
+
+	if len(denied) > 0 {
+		result.Status = CheckWarn
+		if hard {
+			result.Status = CheckFail
+		}
+		result.Message = fmt.Sprintf("missing verbs %s", strings.Join(denied, ", "))
+	}
+	return result
+}
+
+// checkExists verifies an object exists on the target cluster, it's a warning if it can't be read or the
+// target is unreachable
+func (i *PropagationController) checkExists(ctx context.Context, name string, obj client.Object, key client.ObjectKey) CheckResult {
+	object := key.Name
+	if key.Namespace != "" {
+		object = key.String()
+	}
+	result := CheckResult{Name: name, Status: CheckPass, Message: object}
+	err := i.TargetClient.Get(ctx, key, obj)
+	switch {
+	case err == nil:
+	case k8serrors.IsNotFound(err) || meta.IsNoMatchError(err):
+		result.Status, result.Message = CheckFail, fmt.Sprintf("%s does not exist", object)
+	case k8serrors.IsForbidden(err):
+		result.Status, result.Message = CheckWarn, fmt.Sprintf("%s can't be verified, access is forbidden", object)
+	default:
+		result.Status, result.Message = CheckWarn, fmt.Sprintf("%s can't be verified: %s", object, err)
+	}
+	return result
+}
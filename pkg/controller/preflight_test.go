@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestCheckPermission(t *testing.T) {
+	write := TargetPermission{Group: "networking.k8s.io", Resource: "ingresses", Verbs: []string{"get", "create"}}
+	preflight := TargetPermission{Group: "networking.k8s.io", Resource: "ingressclasses", Verbs: []string{"get"}, ClusterScoped: true, Preflight: true}
+	tests := []struct {
+		name       string
+		permission TargetPermission
+		allowed    []string
+		dryRun     bool
+		reviewErr  error
+		want       string
+	}{
+		{name: "allowed", permission: write, allowed: []string{"get", "create"}, want: CheckPass},
+		{name: "write denied", permission: write, allowed: []string{"get"}, want: CheckFail},
+		{name: "write denied in dry run", permission: write, allowed: []string{"get"}, dryRun: true, want: CheckWarn},
+		{name: "read denied in dry run", permission: write, allowed: []string{"create"}, dryRun: true, want: CheckFail},
+		{name: "preflight read denied", permission: preflight, want: CheckWarn},
+		{name: "target unreachable", permission: write, reviewErr: errors.New("dial tcp: i/o timeout"), want: CheckWarn},
+		{name: "target unavailable", permission: write, reviewErr: k8serrors.NewServiceUnavailable("apiserver is shutting down"), want: CheckWarn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{DryRun: tt.dryRun}, nil, nil)
+			i.TargetClient = interceptor.NewClient(fake.NewClientBuilder().WithScheme(testScheme).Build(), interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if tt.reviewErr != nil {
+						return tt.reviewErr
+					}
+					review := obj.(*authorizationv1.SelfSubjectAccessReview)
+					review.Status.Allowed = stringSliceContains(tt.allowed, review.Spec.ResourceAttributes.Verb)
+					return nil
+				},
+			})
+
+			if got := i.checkPermission(context.Background(), tt.permission); got.Status != tt.want {
+				t.Errorf("checkPermission() = %s (%s), want %s", got.Status, got.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckExists(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists bool
+		getErr error
+		want   string
+	}{
+		{name: "exists", exists: true, want: CheckPass},
+		{name: "not found", want: CheckFail},
+		{name: "forbidden", getErr: k8serrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "target", errors.New("denied")), want: CheckWarn},
+		{name: "target unreachable", getErr: errors.New("dial tcp: connection refused"), want: CheckWarn},
+		{name: "target timeout", getErr: k8serrors.NewTimeoutError("request timed out", 1), want: CheckWarn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []client.Object
+			if tt.exists {
+				objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "target"}})
+			}
+			i := testController(PropagationControllerOptions{}, nil, nil)
+			i.TargetClient = interceptor.NewClient(fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).Build(), interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if tt.getErr != nil {
+						return tt.getErr
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			})
+
+			got := i.checkExists(context.Background(), "target namespace", &corev1.Namespace{}, client.ObjectKey{Name: "target"})
+			if got.Status != tt.want {
+				t.Errorf("checkExists() = %s (%s), want %s", got.Status, got.Message, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/metrics"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// readinessState tracks since when a propagation is waiting for the target to converge
+type readinessState struct {
+	generation int64
+	since      time.Time
+	ready      bool
+	degraded   bool
+}
+
+type readinessTracker struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]*readinessState
+}
+
+// observe records the readiness of an origin and returns since when it is pending and whether it changed to ready
+func (t *readinessTracker) observe(origin networkingv1.Ingress, ready bool) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.states == nil {
+		t.states = map[types.NamespacedName]*readinessState{}
+	}
+
+	key := types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name}
+	state, ok := t.states[key]
+	if !ok || state.generation != origin.Generation {
+		state = &readinessState{generation: origin.Generation, since: time.Now()}
+		t.states[key] = state
+	}
+
+	changed := ready && !state.ready
+	if !ready && state.ready {
+		state.since = time.Now()
+	}
+	if ready {
+		state.degraded = false
+	}
+	state.ready = ready
+	return state.since, changed
+}
+
+// degrade records an origin as degraded and returns whether it wasn't before
+func (t *readinessTracker) degrade(origin networkingv1.Ingress) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name}]
+	if !ok || state.degraded {
+		return false
+	}
+	state.degraded = true
+	return true
+}
+
+func (t *readinessTracker) forget(origin networkingv1.Ingress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.states, types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name})
+}
+
+// checkReadiness returns whether the propagation converged on the target cluster, if not the reason is returned
+func (i *PropagationController) checkReadiness(ctx context.Context, prop propagation.Propagation) (bool, string, error) {
+	ingress := networkingv1.Ingress{}
+	err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &ingress)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get ingress %s on target cluster: %s", prop.Ingress.Name, err)
+	}
+	if len(ingress.Status.LoadBalancer.Ingress) == 0 {
+		return false, fmt.Sprintf("ingress %s has no address on target cluster", ingress.Name), nil
+	}
+
+	// Certificates are named after their secret, by ingress-shim as well as in certificate mode
+	for _, name := range prop.IssuedSecrets {
+		certificate := unstructured.Unstructured{}
+		certificate.SetGroupVersionKind(CertificateGVK)
+		err := i.TargetClient.Get(ctx, types.NamespacedName{Namespace: i.Options.TargetNamespace, Name: name}, &certificate)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return false, fmt.Sprintf("certificate %s does not exist on target cluster", name), nil
+			}
+			return false, "", fmt.Errorf("failed to get certificate %s on target cluster: %s", name, err)
+		}
+		if status, reason, message := certificateReady(certificate); status != metav1.ConditionTrue {
+			return false, fmt.Sprintf("certificate %s is not ready (%s): %s", name, reason, message), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// waitForReadiness reports the propagation as ready once the target converged and returns the duration
+// after which readiness should be checked again (0 if ready)
+func (i *PropagationController) waitForReadiness(ctx context.Context, prop propagation.Propagation, report *statusReport) (time.Duration, error) {
+	ready, reason, err := i.checkReadiness(ctx, prop)
+	if err != nil {
+		return 0, err
+	}
+
+	since, changed := i.readiness.observe(prop.Origin, ready)
+	if ready {
+		if changed {
+			i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressPropagated", "Ingress has been propagated and is ready on target cluster")
+		}
+		i.Metrics.SetState(client.ObjectKeyFromObject(&prop.Origin), metrics.StateReady)
+		report.set(prop,
+			condition(v1alpha1.ConditionReady, metav1.ConditionTrue, "Ready", "Propagation is ready on target cluster"),
+			condition(v1alpha1.ConditionDegraded, metav1.ConditionFalse, "Ready", ""),
+		)
+		return 0, nil
+	}
+
+	state := metrics.StatePending
+	degraded := condition(v1alpha1.ConditionDegraded, metav1.ConditionFalse, "Pending", "")
+	if time.Since(since) > i.Options.ReadinessTimeout {
+		state = metrics.StateDegraded
+		if i.readiness.degrade(prop.Origin) {
+			i.Recorder.Eventf(&prop.Origin, corev1.EventTypeWarning, "PropagationDegraded", "Propagation not ready after %s: %s", i.Options.ReadinessTimeout, reason)
+		}
+		degraded = condition(v1alpha1.ConditionDegraded, metav1.ConditionTrue, "ReadinessTimeout", reason)
+	}
+	i.Metrics.SetState(client.ObjectKeyFromObject(&prop.Origin), state)
+	report.set(prop, condition(v1alpha1.ConditionReady, metav1.ConditionFalse, "Pending", reason), degraded)
+	return i.Options.ReadinessInterval, nil
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadinessTracker(t *testing.T) {
+	origin := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", Generation: 1}}
+	tracker := readinessTracker{}
+
+	if _, changed := tracker.observe(origin, false); changed {
+		t.Errorf("pending origin reported as changed to ready")
+	}
+	if !tracker.degrade(origin) {
+		t.Errorf("first degrade not reported")
+	}
+	if tracker.degrade(origin) {
+		t.Errorf("degrade reported again without a transition")
+	}
+	if _, changed := tracker.observe(origin, true); !changed {
+		t.Errorf("ready origin not reported as changed")
+	}
+	if _, changed := tracker.observe(origin, true); changed {
+		t.Errorf("ready origin reported as changed twice")
+	}
+	tracker.observe(origin, false)
+	if !tracker.degrade(origin) {
+		t.Errorf("degrade not reported after the origin was ready again")
+	}
+
+	// A new generation starts over
+	origin.Generation = 2
+	tracker.observe(origin, false)
+	if !tracker.degrade(origin) {
+		t.Errorf("degrade not reported for a new generation")
+	}
+	tracker.forget(origin)
+	if tracker.degrade(origin) {
+		t.Errorf("forgotten origin reported as degraded")
+	}
+}
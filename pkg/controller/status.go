@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// condition returns a condition of the given type, status and reason
+func condition(conditionType string, status metav1.ConditionStatus, reason string, message string) metav1.Condition {
+	return metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// terminal returns the terminal condition of the result of a propagation
+func terminal(err error) metav1.Condition {
+	if permanent, ok := IsPermanent(err); ok {
+		return condition(v1alpha1.ConditionTerminal, metav1.ConditionTrue, permanent.Reason, err.Error())
+	}
+	if err != nil {
+		return condition(v1alpha1.ConditionTerminal, metav1.ConditionFalse, "Retrying", "Transient error, retried with backoff")
+	}
+	return condition(v1alpha1.ConditionTerminal, metav1.ConditionFalse, "Propagated", "")
+}
+
+// statusReport collects the conditions reported during a reconciliation, the propagation status is
+// written once at the end
+type statusReport struct {
+	prop       propagation.Propagation
+	conditions []metav1.Condition
+}
+
+// set records conditions of a propagation, later conditions of the same type replace earlier ones
+func (r *statusReport) set(prop propagation.Propagation, conditions ...metav1.Condition) {
+	r.prop = prop
+	r.conditions = append(r.conditions, conditions...)
+}
+
+// writeStatus creates or updates the propagation resource of the origin ingress with the collected conditions.
+// The status is only written if it changed.
+func (i *PropagationController) writeStatus(ctx context.Context, report *statusReport) error {
+	// A dry run doesn't write to the source cluster either
+	if !i.Options.PropagationStatus || i.Options.DryRun || len(report.conditions) == 0 {
+		return nil
+	}
+
+	prop := report.prop
+	origin := prop.Origin
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		status := v1alpha1.Propagation{}
+		err := i.Client.Get(ctx, client.ObjectKeyFromObject(&origin), &status)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil {
+			status = v1alpha1.Propagation{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      origin.Name,
+					Namespace: origin.Namespace,
+					Labels: map[string]string{
+						LabelManaged: i.Options.Identifier,
+					},
+				},
+				Spec: v1alpha1.PropagationSpec{
+					Ingress: origin.Name,
+				},
+			}
+			if err := controllerutil.SetControllerReference(&origin, &status, i.Client.Scheme()); err != nil {
+				return err
+			}
+			if err := i.Client.Create(ctx, &status); err != nil {
+				return err
+			}
+		}
+
+		observed := status.Status.DeepCopy()
+		status.Status.Identifier = i.Options.Identifier
+		status.Status.TargetName = prop.Ingress.Name
+		status.Status.TargetNamespace = prop.Ingress.Namespace
+		status.Status.Objects = propagatedObjects(prop)
+		status.Status.Addresses = propagatedAddresses(prop)
+		status.Status.ObservedGeneration = origin.Generation
+		synced := false
+		for _, c := range report.conditions {
+			c.ObservedGeneration = origin.Generation
+			if c.Type == v1alpha1.ConditionSynced {
+				synced = c.Status == metav1.ConditionTrue
+			}
+			meta.SetStatusCondition(&status.Status.Conditions, c)
+		}
+		if equality.Semantic.DeepEqual(*observed, status.Status) {
+			return nil
+		}
+		// Syncs without changes leave the last sync time as is
+		if synced {
+			now := metav1.Now()
+			status.Status.LastSyncTime = &now
+		}
+		return i.Client.Status().Update(ctx, &status)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write propagation status %s/%s: %s", origin.Namespace, origin.Name, err)
+	}
+	return nil
+}
+
+// propagatedObjects returns references to all objects of a propagation on the target cluster
+func propagatedObjects(prop propagation.Propagation) []v1alpha1.PropagatedObject {
+	if prop.Ingress.Name == "" {
+		return nil
+	}
+
+	objects := []v1alpha1.PropagatedObject{{Kind: "Ingress", Name: prop.Ingress.Name}}
+	for _, service := range prop.Services {
+		objects = append(objects, v1alpha1.PropagatedObject{Kind: "Service", Name: service.Name})
+	}
+	for _, endpoint := range prop.Endpoints {
+		objects = append(objects, v1alpha1.PropagatedObject{Kind: "Endpoints", Name: endpoint.Name})
+	}
+	for _, secret := range prop.Secrets {
+		objects = append(objects, v1alpha1.PropagatedObject{Kind: "Secret", Name: secret.Name})
+	}
+	for _, certificate := range prop.Certificates {
+		objects = append(objects, v1alpha1.PropagatedObject{Kind: certificate.GetKind(), Name: certificate.GetName()})
+	}
+	return objects
+}
+
+// propagatedAddresses returns all backend addresses and hostnames of a propagation
+func propagatedAddresses(prop propagation.Propagation) []string {
+	var addresses []string
+	for _, service := range prop.Services {
+		if service.Spec.ExternalName != "" && !stringSliceContains(addresses, service.Spec.ExternalName) {
+			addresses = append(addresses, service.Spec.ExternalName)
+		}
+	}
+	for _, target := range probeTargets(prop) {
+		if !stringSliceContains(addresses, target.Address) {
+			addresses = append(addresses, target.Address)
+		}
+	}
+	return addresses
+}
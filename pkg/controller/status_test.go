@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTerminal(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status metav1.ConditionStatus
+		reason string
+	}{
+		{name: "propagated", status: metav1.ConditionFalse, reason: "Propagated"},
+		{name: "transient", err: errors.New("connection refused"), status: metav1.ConditionFalse, reason: "Retrying"},
+		{name: "permanent", err: permanentf(ReasonHostNotAllowed, "host not allowed"), status: metav1.ConditionTrue, reason: ReasonHostNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := terminal(tt.err)
+			if got.Type != v1alpha1.ConditionTerminal || got.Status != tt.status || got.Reason != tt.reason {
+				t.Errorf("terminal() = %s %s %s, want %s %s", got.Type, got.Status, got.Reason, tt.status, tt.reason)
+			}
+		})
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	origin := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default", Generation: 1}}
+	i := testController(PropagationControllerOptions{PropagationStatus: true}, []client.Object{origin}, nil)
+	prop := propagation.Propagation{
+		Name:    "shop",
+		Origin:  *origin,
+		Ingress: networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target"}},
+	}
+	ctx := context.Background()
+	get := func() v1alpha1.Propagation {
+		t.Helper()
+		status := v1alpha1.Propagation{}
+		if err := i.Client.Get(ctx, client.ObjectKeyFromObject(origin), &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	// Conditions of one reconciliation are written at once
+	report := &statusReport{}
+	report.set(prop, condition(v1alpha1.ConditionSynced, metav1.ConditionTrue, "Synced", ""), terminal(nil))
+	report.set(prop, condition(v1alpha1.ConditionReady, metav1.ConditionTrue, "Ready", ""))
+	if err := i.writeStatus(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	status := get()
+	for _, conditionType := range []string{v1alpha1.ConditionSynced, v1alpha1.ConditionReady} {
+		if !meta.IsStatusConditionTrue(status.Status.Conditions, conditionType) {
+			t.Errorf("condition %s is not true: %v", conditionType, status.Status.Conditions)
+		}
+	}
+	if status.Status.LastSyncTime == nil || status.Status.TargetName != "id-shop" {
+		t.Fatalf("status not written: %+v", status.Status)
+	}
+	synced := status.Status.LastSyncTime
+
+	// A sync without changes leaves the status as is
+	report = &statusReport{}
+	report.set(prop, condition(v1alpha1.ConditionSynced, metav1.ConditionTrue, "Synced", ""), terminal(nil))
+	if err := i.writeStatus(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	unchanged := get()
+	if unchanged.ResourceVersion != status.ResourceVersion || !unchanged.Status.LastSyncTime.Equal(synced) {
+		t.Errorf("status written without changes")
+	}
+
+	// Failures keep the last sync time
+	report = &statusReport{}
+	report.set(prop, condition(v1alpha1.ConditionSynced, metav1.ConditionFalse, "TargetWriteFailed", "unreachable"))
+	if err := i.writeStatus(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	failed := get()
+	if meta.IsStatusConditionTrue(failed.Status.Conditions, v1alpha1.ConditionSynced) || !failed.Status.LastSyncTime.Equal(synced) {
+		t.Errorf("failed sync not reported or last sync time changed: %+v", failed.Status)
+	}
+}
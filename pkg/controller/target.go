@@ -3,28 +3,75 @@ package controller
 import (
 	"context"
 	"fmt"
+	"strings"
 
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/diff"
 	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
 
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// ConflictError is returned if an object written by the propagator belongs to another origin
+type ConflictError struct {
+	Kind  string
+	Name  string
+	Owner string
+	// Cluster holding the object, defaults to the target cluster
+	Cluster string
+}
+
+func (e *ConflictError) Error() string {
+	cluster := e.Cluster
+	if cluster == "" {
+		cluster = "target"
+	}
+	return fmt.Sprintf("%s %s on %s cluster is owned by %s", e.Kind, e.Name, cluster, e.Owner)
+}
+
 func (i *PropagationController) putPropagation(ctx context.Context, prop propagation.Propagation) error {
+	// Refuse to overwrite ingresses of other propagators or origins
+	existing := v1.Ingress{}
+	err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&prop.Ingress), &existing)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get ingress on target cluster %s: %s", prop.Ingress.Name, err)
+	}
+	if err == nil {
+		// Orphaned ingresses keep the origin annotation, the same origin adopts them again
+		orphaned := existing.Labels[LabelManaged] == "" && existing.Annotations[AnnotationOrigin] == prop.Ingress.Annotations[AnnotationOrigin]
+		if managed := existing.Labels[LabelManaged]; managed != i.Options.Identifier && !orphaned {
+			return &ConflictError{Kind: "Ingress", Name: existing.Name, Owner: fmt.Sprintf("propagator %q", managed)}
+		}
+		if origin, ok := existing.Annotations[AnnotationOrigin]; ok && origin != prop.Ingress.Annotations[AnnotationOrigin] {
+			return &ConflictError{Kind: "Ingress", Name: existing.Name, Owner: fmt.Sprintf("ingress %s", origin)}
+		}
+	}
+
+	if i.Options.DryRun {
+		changes, err := i.Diff(ctx, prop)
+		if err != nil {
+			return err
+		}
+		i.reportDryRun(ctx, prop, changes)
+		return nil
+	}
+
 	// Try to update the ingress
-	err := i.TargetClient.Update(ctx, &prop.Ingress)
+	err = i.TargetClient.Update(ctx, &prop.Ingress)
 	if err != nil {
 		// If error is because the resource doesn't exist, then create it
 		if k8serrors.IsNotFound(err) {
 			err = i.TargetClient.Create(ctx, &prop.Ingress)
 			if err != nil {
-				return fmt.Errorf("failed to create ingress %s: %s", prop.Ingress.Name, err)
+				return targetErrorf(err, "failed to create ingress %s", prop.Ingress.Name)
 			}
 		} else {
-			return fmt.Errorf("failed to update ingress %s: %s", prop.Ingress.Name, err)
+			return targetErrorf(err, "failed to update ingress %s", prop.Ingress.Name)
 		}
 	}
 	// Fetch the updated ingress to get the UID
@@ -51,10 +98,10 @@ func (i *PropagationController) putPropagation(ctx context.Context, prop propaga
 			if k8serrors.IsNotFound(err) {
 				err = i.TargetClient.Create(ctx, &endpoint)
 				if err != nil {
-					return fmt.Errorf("failed to create endpoint %s in namespace %s: %s", endpoint.Name, endpoint.Namespace, err)
+					return targetErrorf(err, "failed to create endpoint %s in namespace %s", endpoint.Name, endpoint.Namespace)
 				}
 			} else {
-				return fmt.Errorf("failed to update endpoint %s in namespace %s: %s", endpoint.Name, endpoint.Namespace, err)
+				return targetErrorf(err, "failed to update endpoint %s in namespace %s", endpoint.Name, endpoint.Namespace)
 			}
 		}
 	}
@@ -66,26 +113,97 @@ func (i *PropagationController) putPropagation(ctx context.Context, prop propaga
 			if k8serrors.IsNotFound(err) {
 				err = i.TargetClient.Create(ctx, &service)
 				if err != nil {
-					return fmt.Errorf("failed to create service %s in namespace %s: %s", service.Name, service.Namespace, err)
+					return targetErrorf(err, "failed to create service %s in namespace %s", service.Name, service.Namespace)
+				}
+			} else {
+				return targetErrorf(err, "failed to update service %s in namespace %s", service.Name, service.Namespace)
+			}
+		}
+	}
+
+	for _, secret := range prop.Secrets {
+		secret.OwnerReferences = append(secret.OwnerReferences, ownerRef)
+		err := i.TargetClient.Update(ctx, &secret)
+		if err != nil {
+			// If error is because the resource doesn't exist, then create it
+			if k8serrors.IsNotFound(err) {
+				err = i.TargetClient.Create(ctx, &secret)
+				if err != nil {
+					return targetErrorf(err, "failed to create secret %s in namespace %s", secret.Name, secret.Namespace)
 				}
 			} else {
-				return fmt.Errorf("failed to update service %s in namespace %s: %s", service.Name, service.Namespace, err)
+				return targetErrorf(err, "failed to update secret %s in namespace %s", secret.Name, secret.Namespace)
 			}
 		}
 	}
 
-	i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressPropagated", "Ingress has been propagated")
+	for _, certificate := range prop.Certificates {
+		certificate.SetOwnerReferences(append(certificate.GetOwnerReferences(), ownerRef))
+		// Custom resources can't be updated without resource version
+		existing := unstructured.Unstructured{}
+		existing.SetGroupVersionKind(certificate.GroupVersionKind())
+		err := i.TargetClient.Get(ctx, client.ObjectKeyFromObject(&certificate), &existing)
+		if err != nil {
+			if !k8serrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get certificate %s in namespace %s: %s", certificate.GetName(), certificate.GetNamespace(), err)
+			}
+			err = i.TargetClient.Create(ctx, &certificate)
+			if err != nil {
+				return targetErrorf(err, "failed to create certificate %s in namespace %s", certificate.GetName(), certificate.GetNamespace())
+			}
+			continue
+		}
+		certificate.SetResourceVersion(existing.GetResourceVersion())
+		err = i.TargetClient.Update(ctx, &certificate)
+		if err != nil {
+			return targetErrorf(err, "failed to update certificate %s in namespace %s", certificate.GetName(), certificate.GetNamespace())
+		}
+	}
+
+	// Remove objects which are no longer desired, eg. backends removed from the origin or endpoints of
+	// ExternalName services
+	stale, err := i.staleObjects(ctx, prop)
+	if err != nil {
+		return err
+	}
+	for _, obj := range stale {
+		err := i.TargetClient.Delete(ctx, obj.object)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s in namespace %s: %s", strings.ToLower(obj.kind), obj.object.GetName(), i.Options.TargetNamespace, err)
+		}
+	}
+
 	return nil
 }
 
 func (i *PropagationController) removePropagation(ctx context.Context, prop propagation.Propagation) error {
-	err := i.TargetClient.Delete(ctx, &prop.Ingress)
-	if !k8serrors.IsNotFound(err) {
+	policy := i.deletionPolicy(prop.Origin)
+	if i.Options.DryRun {
+		changes, err := i.DiffRemoval(ctx, prop)
 		if err != nil {
-			return fmt.Errorf("failed to delete ingress %s: %s", prop.Ingress.Name, err)
+			return err
 		}
+		// Kept objects are only updated
+		if policy != DeletionPolicyDelete {
+			for c := range changes {
+				changes[c].Action = diff.ActionUpdate
+			}
+		}
+		i.reportDryRun(ctx, prop, changes)
+		return nil
 	}
 
-	i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressUnpropagated", "Ingress has been removed")
+	if err := i.removeTarget(ctx, prop, policy); err != nil {
+		return err
+	}
+
+	switch policy {
+	case DeletionPolicyOrphan:
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressOrphaned", "Ingress has been left on the target cluster")
+	case DeletionPolicyRetain:
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressRetained", "Ingress has been retained on the target cluster")
+	default:
+		i.Recorder.Eventf(&prop.Origin, corev1.EventTypeNormal, "IngressUnpropagated", "Ingress has been removed")
+	}
 	return nil
 }
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestPutPropagationRemovesStaleBackends(t *testing.T) {
+	labels := map[string]string{LabelManaged: "id", LabelPropagator: "shop"}
+	i := testController(PropagationControllerOptions{TargetIPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+	}, []client.Object{
+		// Leftovers of a previous propagation with a single backend name
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target", Labels: labels}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "id-shop", Namespace: "target", Labels: labels}},
+		// Other propagations are left untouched
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "id-blog-web", Namespace: "target", Labels: map[string]string{LabelManaged: "id", LabelPropagator: "blog"}}},
+	})
+
+	ctx := context.Background()
+	prop, err := i.FromIngressToPropagation(ctx, i.Client, testIngress("shop", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.putPropagation(ctx, prop); err != nil {
+		t.Fatal(err)
+	}
+
+	services := corev1.ServiceList{}
+	if err := i.TargetClient.List(ctx, &services); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, service := range services.Items {
+		names = append(names, service.Name)
+	}
+	if len(names) != 2 || names[0] != "id-blog-web" || names[1] != "id-shop-web" {
+		t.Errorf("services = %v, want [id-blog-web id-shop-web]", names)
+	}
+
+	endpoints := corev1.Endpoints{}
+	if err := i.TargetClient.Get(ctx, client.ObjectKey{Namespace: "target", Name: "id-shop"}, &endpoints); err == nil {
+		t.Errorf("stale endpoints id-shop were not removed")
+	}
+	changes, err := i.Diff(ctx, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("diff after put = %v, want no changes", changes)
+	}
+}
+
+func TestPutPropagationWithoutSecretAccess(t *testing.T) {
+	i := testController(PropagationControllerOptions{TargetIPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+	}, nil)
+	// Plain propagations don't require access to secrets on the target cluster
+	i.TargetClient = interceptor.NewClient(i.TargetClient.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*corev1.SecretList); ok {
+				return k8serrors.NewForbidden(corev1.Resource("secrets"), "", errors.New("denied"))
+			}
+			return c.List(ctx, list, opts...)
+		},
+	})
+
+	ctx := context.Background()
+	prop, err := i.FromIngressToPropagation(ctx, i.Client, testIngress("shop", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.putPropagation(ctx, prop); err != nil {
+		t.Fatal(err)
+	}
+}
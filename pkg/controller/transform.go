@@ -2,25 +2,35 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/prober"
 	"github.com/buttahtoast/svc-ingress-propagator/pkg/propagation"
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/tracing"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-var (
-	hosts    []string
-	services []v1.Service
-)
+func (i *PropagationController) FromIngressToPropagation(ctx context.Context, kubeClient client.Client, ingress networkingv1.Ingress) (_ propagation.Propagation, err error) {
+	ctx, span := tracing.Start(ctx, "FromIngressToPropagation",
+		tracing.AttributeIngress.String(fmt.Sprintf("%s/%s", ingress.Namespace, ingress.Name)),
+		tracing.AttributeIdentifier.String(i.Options.Identifier),
+		tracing.AttributeTargetNamespace.String(i.Options.TargetNamespace),
+	)
+	defer func() { tracing.End(span, err) }()
 
-func (i *PropagationController) FromIngressToPropagation(ctx context.Context, logger logr.Logger, kubeClient client.Client, ingress networkingv1.Ingress) (propagation.Propagation, error) {
 	result := propagation.Propagation{
 		Name:           ingress.Name,
 		PropagatedName: fmt.Sprintf("%s-%s", i.Options.Identifier, ingress.Name),
@@ -34,96 +44,161 @@ func (i *PropagationController) FromIngressToPropagation(ctx context.Context, lo
 		Origin: ingress,
 	}
 
+	var hosts []string
+	var services []v1.Service
+
+	// Work on a copy, the origin ingress must not be modified
+	source := ingress.DeepCopy()
+
 	if ingress.DeletionTimestamp != nil {
 		result.IsDeleted = true
 	} else {
 
 		// Assign Labels
 		result.Ingress.Labels = make(map[string]string)
-		if ingress.Labels != nil {
-			result.Ingress.Labels = ingress.Labels
+		if source.Labels != nil {
+			result.Ingress.Labels = source.Labels
 		}
 		result.Ingress.Labels[LabelManaged] = i.Options.Identifier
 
 		// Annotations
 		result.Ingress.Annotations = make(map[string]string)
-		if ingress.Annotations != nil {
-			result.Ingress.Annotations = ingress.Annotations
+		if source.Annotations != nil {
+			result.Ingress.Annotations = source.Annotations
+		}
+
+		result.Ingress.Annotations[AnnotationOrigin] = fmt.Sprintf("%s/%s", ingress.GetNamespace(), ingress.GetName())
+		if policy, ok := source.Annotations[AnnotationDeletionPolicy]; ok && !validDeletionPolicy(policy) {
+			return result, permanentf(ReasonInvalidIngress, "unknown deletion policy %s of ingress %s/%s", policy, ingress.GetNamespace(), ingress.GetName())
 		}
 
 		result.Ingress.Spec.IngressClassName = &i.Options.TargetIngressClassName
 
-		result.Ingress.Spec.Rules = ingress.Spec.Rules
+		result.Ingress.Spec.Rules = source.Spec.Rules
 		for r := range result.Ingress.Spec.Rules {
 			rule := &result.Ingress.Spec.Rules[r]
 			if rule.Host == "" {
-				return result, fmt.Errorf("host in ingress %s/%s is empty", ingress.GetNamespace(), ingress.GetName())
+				return result, permanentf(ReasonInvalidIngress, "host in ingress %s/%s is empty", ingress.GetNamespace(), ingress.GetName())
+			}
+			if !i.hostAllowed(rule.Host) {
+				return result, permanentf(ReasonHostNotAllowed, "host %s in ingress %s/%s is not within the allowed domains", rule.Host, ingress.GetNamespace(), ingress.GetName())
 			}
 
+			if rule.HTTP == nil {
+				return result, permanentf(ReasonInvalidIngress, "host %s in ingress %s/%s has no http paths", rule.Host, ingress.GetNamespace(), ingress.GetName())
+			}
 			for p := range rule.HTTP.Paths {
 				path := &rule.HTTP.Paths[p]
+				if path.Backend.Service == nil {
+					return result, permanentf(ReasonInvalidIngress, "path %s of host %s in ingress %s/%s has no service backend", path.Path, rule.Host, ingress.GetNamespace(), ingress.GetName())
+				}
 
 				namespacedName := types.NamespacedName{
 					Namespace: ingress.GetNamespace(),
 					Name:      path.Backend.Service.Name,
 				}
 				service := v1.Service{}
-				err := kubeClient.Get(ctx, namespacedName, &service)
+				lookupCtx, lookupSpan := tracing.Start(ctx, "GetBackendService", tracing.AttributeService.String(namespacedName.String()))
+				err := kubeClient.Get(lookupCtx, namespacedName, &service)
+				tracing.End(lookupSpan, err)
 				if err != nil {
 					return result, fmt.Errorf("fetch service %s: %s", namespacedName, err)
 				}
 
 				if service.Status.LoadBalancer.Ingress == nil {
-					return result, fmt.Errorf("service %s has no loadbalancer ip", namespacedName)
+					if !stringSliceContains(result.BackendsWithoutAddress, service.Name) {
+						result.BackendsWithoutAddress = append(result.BackendsWithoutAddress, service.Name)
+					}
+					if !i.Options.NodePortFallback {
+						return result, fmt.Errorf("service %s has no loadbalancer ip", namespacedName)
+					}
 				}
 
 				var port int32
 				if path.Backend.Service.Port.Name != "" {
 					ok, extractedPort := getPortWithName(service.Spec.Ports, path.Backend.Service.Port.Name)
 					if !ok {
-						return result, fmt.Errorf("service %s has no port named %s", namespacedName, path.Backend.Service.Port.Name)
+						return result, permanentf(ReasonUnknownPort, "service %s has no port named %s", namespacedName, path.Backend.Service.Port.Name)
 					}
 					port = extractedPort
 				} else {
 					port = path.Backend.Service.Port.Number
 				}
 
-				service.ObjectMeta.Name = result.PropagatedName
 				if !containsService(services, path.Backend.Service.Name) {
 					services = append(services, service)
 				}
 
 				path.Backend = networkingv1.IngressBackend{
 					Service: &networkingv1.IngressServiceBackend{
-						Name: result.PropagatedName,
+						Name: backendName(result.PropagatedName, service.Name),
 						Port: networkingv1.ServiceBackendPort{
 							Number: port,
 						},
 					},
 				}
 			}
-			hosts = append(hosts, rule.Host)
+			if !stringSliceContains(hosts, rule.Host) {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+
+		// Hosts of tls entries end up in certificates, they must be allowed as well
+		for _, tls := range source.Spec.TLS {
+			for _, host := range tls.Hosts {
+				if !i.hostAllowed(host) {
+					return result, permanentf(ReasonHostNotAllowed, "tls host %s in ingress %s/%s is not within the allowed domains", host, ingress.GetNamespace(), ingress.GetName())
+				}
+			}
 		}
 
-		// Add TLS information
-		if (i.Options.TLSrespect) && (ingress.Spec.TLS != nil) {
-			result.Ingress.Spec.TLS = ingress.Spec.TLS
+		// Add TLS information, referenced secrets are propagated along
+		if (i.Options.TLSrespect) && (source.Spec.TLS != nil) {
+			result.Ingress.Spec.TLS = source.Spec.TLS
+			for t := range result.Ingress.Spec.TLS {
+				tls := &result.Ingress.Spec.TLS[t]
+				if tls.SecretName == "" {
+					continue
+				}
+
+				secret, err := i.propagateTLSSecret(ctx, kubeClient, &result, types.NamespacedName{
+					Namespace: ingress.GetNamespace(),
+					Name:      tls.SecretName,
+				})
+				if err != nil {
+					return result, err
+				}
+				tls.SecretName = secret.Name
+			}
 		}
 
 		if i.Options.TargetIssuerName != "" {
-			if i.Options.TargetIssuerNamespaced {
-				result.Ingress.Annotations[IssuerNamespacedAnnotation] = i.Options.TargetIssuerName
-			} else {
-				result.Ingress.Annotations[IssuerClusterAnnotation] = i.Options.TargetIssuerName
-			}
-			result.Ingress.Spec.TLS = append(result.Ingress.Spec.TLS, networkingv1.IngressTLS{
-				Hosts:      hosts,
-				SecretName: result.PropagatedName,
-			})
+			if i.Options.TargetCertificateMode != CertificateModeResource {
+				if i.Options.TargetIssuerNamespaced {
+					result.Ingress.Annotations[IssuerNamespacedAnnotation] = i.Options.TargetIssuerName
+				} else {
+					result.Ingress.Annotations[IssuerClusterAnnotation] = i.Options.TargetIssuerName
+				}
+			}
+
+			// Hosts of respected tls entries keep their propagated certificate
+			var covered []string
+			for _, tls := range result.Ingress.Spec.TLS {
+				if tls.SecretName != "" {
+					covered = append(covered, tls.Hosts...)
+				}
+			}
+			for _, group := range i.tlsGroups(result.PropagatedName, hosts, ingress.Spec.TLS, covered) {
+				result.Ingress.Spec.TLS = append(result.Ingress.Spec.TLS, group)
+				result.IssuedSecrets = append(result.IssuedSecrets, group.SecretName)
+				if i.Options.TargetCertificateMode == CertificateModeResource {
+					result.Certificates = append(result.Certificates, i.certificateFor(&result, group.SecretName, group.Hosts))
+				}
+			}
 		}
 
 		// Load Services and endpoints
-		err := resolveServiceEndpoints(services, &result, i.Options.Identifier, i.Options.TargetNamespace)
+		err := i.resolveServiceEndpoints(ctx, kubeClient, services, &result)
 		if err != nil {
 			return result, fmt.Errorf("failed to resolve service endpoints: %s", err)
 		}
@@ -131,17 +206,179 @@ func (i *PropagationController) FromIngressToPropagation(ctx context.Context, lo
 	return result, nil
 }
 
-func resolveServiceEndpoints(services []v1.Service, propagation *propagation.Propagation, identifier string, namespace string) error {
+// tlsGroups splits the hosts into tls entries issued on the target cluster, according to the tls strategy.
+// Covered hosts already have a certificate and are left out.
+func (i *PropagationController) tlsGroups(propagatedName string, hosts []string, origin []networkingv1.IngressTLS, covered []string) []networkingv1.IngressTLS {
+	var groups []networkingv1.IngressTLS
+	perHost := func() {
+		for _, host := range hosts {
+			groups = append(groups, networkingv1.IngressTLS{
+				Hosts:      []string{host},
+				SecretName: fmt.Sprintf("%s-%s", propagatedName, shortHash(host)),
+			})
+		}
+	}
+
+	switch i.Options.TLSStrategy {
+	case TLSStrategyPerHost:
+		perHost()
+	case TLSStrategyPerTLS:
+		for _, tls := range origin {
+			if len(tls.Hosts) == 0 {
+				continue
+			}
+			sorted := append([]string{}, tls.Hosts...)
+			sort.Strings(sorted)
+			groups = append(groups, networkingv1.IngressTLS{
+				Hosts:      tls.Hosts,
+				SecretName: fmt.Sprintf("%s-%s", propagatedName, shortHash(strings.Join(sorted, ","))),
+			})
+		}
+		// Ingresses without tls entries have nothing to group by
+		if len(groups) == 0 {
+			perHost()
+		}
+	default:
+		groups = append(groups, networkingv1.IngressTLS{
+			Hosts:      hosts,
+			SecretName: propagatedName,
+		})
+	}
+
+	if len(covered) == 0 {
+		return groups
+	}
+	var uncovered []networkingv1.IngressTLS
+	for _, group := range groups {
+		var groupHosts []string
+		for _, host := range group.Hosts {
+			if !stringSliceContains(covered, host) {
+				groupHosts = append(groupHosts, host)
+			}
+		}
+		if len(groupHosts) > 0 {
+			group.Hosts = groupHosts
+			uncovered = append(uncovered, group)
+		}
+	}
+	return uncovered
+}
+
+// hostAllowed returns whether a host is one of the allowed domains or a subdomain of them
+func (i *PropagationController) hostAllowed(host string) bool {
+	if len(i.Options.AllowedDomains) == 0 {
+		return true
+	}
+
+	host = strings.TrimPrefix(strings.ToLower(host), "*.")
+	for _, domain := range i.Options.AllowedDomains {
+		domain = strings.TrimPrefix(strings.ToLower(domain), "*.")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// backendName returns the name of the target service and endpoints of a backend service, names exceeding the
+// limit of service names are shortened with a hash
+func backendName(propagatedName string, service string) string {
+	name := fmt.Sprintf("%s-%s", propagatedName, service)
+	if len(name) <= validation.DNS1035LabelMaxLength {
+		return name
+	}
+	hash := shortHash(name)
+	prefix := strings.TrimRight(name[:validation.DNS1035LabelMaxLength-len(hash)-1], "-")
+	return fmt.Sprintf("%s-%s", prefix, hash)
+}
+
+// shortHash returns a short, deterministic hash used for conflict-free object names
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// propagateTLSSecret adds a copy of a tls secret to the propagation
+func (i *PropagationController) propagateTLSSecret(ctx context.Context, kubeClient client.Client, propagation *propagation.Propagation, namespacedName types.NamespacedName) (v1.Secret, error) {
+	name := fmt.Sprintf("%s-%s", propagation.PropagatedName, namespacedName.Name)
+	for _, secret := range propagation.Secrets {
+		if secret.Name == name {
+			return secret, nil
+		}
+	}
+
+	origin := v1.Secret{}
+	err := kubeClient.Get(ctx, namespacedName, &origin)
+	if err != nil {
+		return v1.Secret{}, fmt.Errorf("fetch secret %s: %s", namespacedName, err)
+	}
+	if origin.Type != v1.SecretTypeTLS {
+		return v1.Secret{}, permanentf(ReasonInvalidSecret, "secret %s is not of type %s", namespacedName, v1.SecretTypeTLS)
+	}
+
+	secret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: i.Options.TargetNamespace,
+			Labels: map[string]string{
+				LabelManaged:    i.Options.Identifier,
+				LabelPropagator: propagation.Name,
+			},
+		},
+		Type: v1.SecretTypeTLS,
+		Data: origin.Data,
+	}
+	propagation.Secrets = append(propagation.Secrets, secret)
+	return secret, nil
+}
+
+func (i *PropagationController) resolveServiceEndpoints(ctx context.Context, kubeClient client.Client, services []v1.Service, propagation *propagation.Propagation) error {
+	identifier := i.Options.Identifier
+	namespace := i.Options.TargetNamespace
+
 	for _, oldService := range services {
-		// Unset any Nodeport
-		for idx := range oldService.Spec.Ports {
-			oldService.Spec.Ports[idx].NodePort = 0
+		ips, hostnames := loadBalancerAddresses(oldService.Status.LoadBalancer.Ingress)
+
+		// Fall back to the node addresses of the source cluster, when there is no loadbalancer address
+		nodePorts := false
+		if len(ips) == 0 && len(hostnames) == 0 {
+			if !i.Options.NodePortFallback {
+				return fmt.Errorf("service %s/%s has no loadbalancer ip", oldService.Namespace, oldService.Name)
+			}
+			nodeIPs, err := i.listNodeAddresses(ctx, kubeClient)
+			if err != nil {
+				return fmt.Errorf("list node addresses for service %s/%s: %s", oldService.Namespace, oldService.Name, err)
+			}
+			ips = nodeIPs
+			nodePorts = true
 		}
 
-		// Create new service struct
+		// Unset any Nodeport, with the nodeport fallback the nodeport becomes the endpoint port
+		ports := make([]v1.ServicePort, 0, len(oldService.Spec.Ports))
+		endpointPorts := make([]v1.EndpointPort, 0, len(oldService.Spec.Ports))
+		for _, port := range oldService.Spec.Ports {
+			endpointPort := port.Port
+			if nodePorts {
+				if port.NodePort == 0 {
+					return fmt.Errorf("service %s/%s has no nodeport for port %d", oldService.Namespace, oldService.Name, port.Port)
+				}
+				endpointPort = port.NodePort
+				port.TargetPort = intstr.FromInt(int(port.NodePort))
+			}
+			port.NodePort = 0
+			ports = append(ports, port)
+			endpointPorts = append(endpointPorts, v1.EndpointPort{
+				Name:     port.Name,
+				Port:     endpointPort,
+				Protocol: port.Protocol,
+			})
+		}
+
+		// Create new service struct, named per backend as an ingress may reference several services
+		name := backendName(propagation.PropagatedName, oldService.Name)
 		service := v1.Service{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      oldService.Name, // Assuming same name, adjust if necessary
+				Name:      name,
 				Namespace: namespace,
 				Labels: map[string]string{
 					LabelManaged:    identifier,
@@ -150,40 +387,71 @@ func resolveServiceEndpoints(services []v1.Service, propagation *propagation.Pro
 			},
 			Spec: v1.ServiceSpec{
 				Type:  "ClusterIP",
-				Ports: oldService.Spec.Ports,
+				Ports: ports,
 				// Add other necessary fields
 			},
 		}
-		propagation.Services = append(propagation.Services, service)
 
-		// Create endpoint for the service
-		endpointSubsets := []v1.EndpointSubset{}
+		// Hostname-only loadbalancers (eg. AWS ELB) can't be expressed as endpoint addresses,
+		// therefor the hostname is propagated as ExternalName service. If a loadbalancer
+		// reports IPs as well, the IPs take precedence and hostnames are ignored.
+		if len(ips) == 0 && len(hostnames) > 0 {
+			service.Spec.Type = v1.ServiceTypeExternalName
+			service.Spec.ExternalName = hostnames[0]
+			propagation.Services = append(propagation.Services, service)
+			continue
+		}
 
-		addresses := []v1.EndpointAddress{}
-		for _, ingress := range oldService.Status.LoadBalancer.Ingress {
-			if ingress.IP != "" {
-				addresses = append(addresses, v1.EndpointAddress{IP: ingress.IP})
-			} else if ingress.Hostname != "" {
-				addresses = append(addresses, v1.EndpointAddress{Hostname: ingress.Hostname})
+		// Group addresses by IP family, families not exported to the target are dropped
+		addresses := map[v1.IPFamily][]v1.EndpointAddress{}
+		for _, ip := range ips {
+			family := ipFamilyOf(ip)
+			if !containsIPFamily(i.Options.TargetIPFamilies, family) {
+				continue
 			}
+			addresses[family] = append(addresses[family], v1.EndpointAddress{IP: ip})
 		}
 
-		for _, port := range oldService.Spec.Ports {
-			endpointSubsets = append(endpointSubsets, v1.EndpointSubset{
-				Addresses: addresses,
-				Ports: []v1.EndpointPort{
-					{
-						Name:     port.Name,
-						Port:     port.Port,
-						Protocol: port.Protocol,
-					},
-				},
-			})
+		var families []v1.IPFamily
+		for _, family := range i.Options.TargetIPFamilies {
+			if len(addresses[family]) > 0 {
+				families = append(families, family)
+			}
+		}
+		if len(families) == 0 {
+			return fmt.Errorf("service %s/%s has no address of families %v", oldService.Namespace, oldService.Name, i.Options.TargetIPFamilies)
+		}
+
+		policy := v1.IPFamilyPolicySingleStack
+		if len(families) > 1 {
+			policy = v1.IPFamilyPolicyPreferDualStack
+		}
+		service.Spec.IPFamilies = families
+		service.Spec.IPFamilyPolicy = &policy
+		propagation.Services = append(propagation.Services, service)
+
+		// Create endpoint for the service, one subset per IP family and port
+		endpointSubsets := []v1.EndpointSubset{}
+		for _, family := range families {
+			for _, port := range endpointPorts {
+				subset := v1.EndpointSubset{
+					Ports: []v1.EndpointPort{port},
+				}
+				// Addresses failing health probes are propagated as not ready
+				for _, address := range addresses[family] {
+					if i.Prober != nil && !i.Prober.Healthy(prober.Target{Address: address.IP, Port: port.Port}) {
+						subset.NotReadyAddresses = append(subset.NotReadyAddresses, address)
+					} else {
+						subset.Addresses = append(subset.Addresses, address)
+					}
+				}
+				endpointSubsets = append(endpointSubsets, subset)
+			}
 		}
 
 		endpoint := v1.Endpoints{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      oldService.Name, // Assuming same name, adjust if necessary
+				Name:      name,
 				Namespace: namespace,
 				Labels: map[string]string{
 					LabelManaged:    identifier,
@@ -198,6 +466,93 @@ func resolveServiceEndpoints(services []v1.Service, propagation *propagation.Pro
 	return nil
 }
 
+// loadBalancerAddresses returns the sorted IPs and hostnames of a loadbalancer status
+func loadBalancerAddresses(ingresses []v1.LoadBalancerIngress) ([]string, []string) {
+	var ips, hostnames []string
+	for _, ingress := range ingresses {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		} else if ingress.Hostname != "" {
+			hostnames = append(hostnames, ingress.Hostname)
+		}
+	}
+	sort.Strings(ips)
+	sort.Strings(hostnames)
+	return ips, hostnames
+}
+
+// listNodeAddresses returns the sorted addresses of all ready nodes matching the node selector
+func (i *PropagationController) listNodeAddresses(ctx context.Context, kubeClient client.Client) ([]string, error) {
+	nodes := v1.NodeList{}
+	err := kubeClient.List(ctx, &nodes, client.MatchingLabelsSelector{Selector: i.Options.NodeSelector})
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, node := range nodes.Items {
+		if !isNodeReady(node) {
+			continue
+		}
+		for _, address := range node.Status.Addresses {
+			if address.Type == i.Options.NodeAddressType {
+				addresses = append(addresses, address.Address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no ready node with address type %s found", i.Options.NodeAddressType)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ipFamilyOf returns the IP family of an address, empty if the address is not a valid IP
+func ipFamilyOf(address string) v1.IPFamily {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return v1.IPv4Protocol
+	}
+	return v1.IPv6Protocol
+}
+
+func containsIPFamily(families []v1.IPFamily, family v1.IPFamily) bool {
+	for _, f := range families {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// probeTargets returns all addresses and ports of the propagated endpoints
+func probeTargets(prop propagation.Propagation) []prober.Target {
+	var targets []prober.Target
+	for _, endpoint := range prop.Endpoints {
+		for _, subset := range endpoint.Subsets {
+			addresses := append(append([]v1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...)
+			for _, address := range addresses {
+				for _, port := range subset.Ports {
+					targets = append(targets, prober.Target{Address: address.IP, Port: port.Port})
+				}
+			}
+		}
+	}
+	return targets
+}
+
 func containsService(services []v1.Service, serviceName string) bool {
 	for _, svc := range services {
 		if svc.Name == serviceName {
@@ -0,0 +1,349 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buttahtoast/svc-ingress-propagator/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testScheme = func() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+	return s
+}()
+
+// testController returns a controller with fake source and target clients
+func testController(options PropagationControllerOptions, source []client.Object, target []client.Object) *PropagationController {
+	if options.Identifier == "" {
+		options.Identifier = "id"
+	}
+	if options.TargetNamespace == "" {
+		options.TargetNamespace = "target"
+	}
+	return &PropagationController{
+		Client:       fake.NewClientBuilder().WithScheme(testScheme).WithObjects(source...).WithStatusSubresource(&v1alpha1.Propagation{}).Build(),
+		TargetClient: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(target...).Build(),
+		Log:          logr.Discard(),
+		Recorder:     record.NewFakeRecorder(100),
+		Options:      options,
+	}
+}
+
+func testService(name string, port int32, addresses ...corev1.LoadBalancerIngress) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: port}}},
+		Status:     corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: addresses}},
+	}
+}
+
+func testPath(path string, service string, port int32) networkingv1.HTTPIngressPath {
+	prefix := networkingv1.PathTypePrefix
+	return networkingv1.HTTPIngressPath{
+		Path:     path,
+		PathType: &prefix,
+		Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+			Name: service,
+			Port: networkingv1.ServiceBackendPort{Number: port},
+		}},
+	}
+}
+
+func testIngress(name string, hosts ...string) networkingv1.Ingress {
+	ingress := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{testPath("/", "web", 80)},
+			}},
+		})
+	}
+	return ingress
+}
+
+func TestFromIngressToPropagationBackends(t *testing.T) {
+	i := testController(PropagationControllerOptions{TargetIPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}, []client.Object{
+		testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}),
+		testService("api", 8080, corev1.LoadBalancerIngress{Hostname: "lb.example.com"}),
+	}, nil)
+
+	ingress := testIngress("shop", "shop.example.com")
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, testPath("/api", "api", 8080))
+
+	prop, err := i.FromIngressToPropagation(context.Background(), i.Client, ingress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := prop.Ingress.Spec.Rules[0].HTTP.Paths
+	if got := paths[0].Backend.Service.Name; got != "id-shop-web" {
+		t.Errorf("backend of / = %s, want id-shop-web", got)
+	}
+	if got := paths[1].Backend.Service.Name; got != "id-shop-api" {
+		t.Errorf("backend of /api = %s, want id-shop-api", got)
+	}
+
+	types := map[string]corev1.ServiceType{}
+	for _, service := range prop.Services {
+		types[service.Name] = service.Spec.Type
+	}
+	if len(types) != 2 || types["id-shop-web"] != corev1.ServiceTypeClusterIP || types["id-shop-api"] != corev1.ServiceTypeExternalName {
+		t.Errorf("services = %v, want ClusterIP id-shop-web and ExternalName id-shop-api", types)
+	}
+	if len(prop.Endpoints) != 1 || prop.Endpoints[0].Name != "id-shop-web" {
+		t.Errorf("endpoints = %v, want id-shop-web only", prop.Endpoints)
+	}
+	if ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name != "web" {
+		t.Errorf("origin ingress was modified")
+	}
+}
+
+func TestFromIngressToPropagationPermanentErrors(t *testing.T) {
+	web := testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"})
+
+	noHTTP := testIngress("shop", "shop.example.com")
+	noHTTP.Spec.Rules[0].HTTP = nil
+	namedPort := testIngress("shop", "shop.example.com")
+	namedPort.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port = networkingv1.ServiceBackendPort{Name: "http"}
+	policy := testIngress("shop", "shop.example.com")
+	policy.Annotations = map[string]string{AnnotationDeletionPolicy: "Forget"}
+
+	tests := []struct {
+		name    string
+		ingress networkingv1.Ingress
+		reason  string
+	}{
+		{name: "empty host", ingress: testIngress("shop", ""), reason: ReasonInvalidIngress},
+		{name: "host not allowed", ingress: testIngress("shop", "shop.example.org"), reason: ReasonHostNotAllowed},
+		{name: "rule without http", ingress: noHTTP, reason: ReasonInvalidIngress},
+		{name: "unknown port name", ingress: namedPort, reason: ReasonUnknownPort},
+		{name: "unknown deletion policy", ingress: policy, reason: ReasonInvalidIngress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{AllowedDomains: []string{"example.com"}}, []client.Object{web.DeepCopy()}, nil)
+			_, err := i.FromIngressToPropagation(context.Background(), i.Client, tt.ingress)
+			permanent, ok := IsPermanent(err)
+			if !ok {
+				t.Fatalf("error %v is not permanent", err)
+			}
+			if permanent.Reason != tt.reason {
+				t.Errorf("reason = %s, want %s", permanent.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestBackendName(t *testing.T) {
+	tests := []struct {
+		name    string
+		prop    string
+		service string
+		want    string
+	}{
+		{name: "short", prop: "id-shop", service: "web", want: "id-shop-web"},
+		{name: "long", prop: "id-" + strings.Repeat("a", 40), service: strings.Repeat("b", 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backendName(tt.prop, tt.service)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("backendName() = %s, want %s", got, tt.want)
+			}
+			if errs := validation.IsDNS1035Label(got); len(errs) > 0 {
+				t.Errorf("backendName() = %s is no valid service name: %v", got, errs)
+			}
+			if got != backendName(tt.prop, tt.service) {
+				t.Errorf("backendName() is not deterministic")
+			}
+		})
+	}
+	if backendName("id-"+strings.Repeat("a", 60), "x") == backendName("id-"+strings.Repeat("a", 60), "y") {
+		t.Errorf("shortened names of different services collide")
+	}
+}
+
+func TestTLSGroups(t *testing.T) {
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com"}
+	origin := []networkingv1.IngressTLS{
+		{Hosts: []string{"a.example.com", "b.example.com"}, SecretName: "ab"},
+		{SecretName: "empty"},
+		{Hosts: []string{"c.example.com"}},
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		origin   []networkingv1.IngressTLS
+		covered  []string
+		want     [][]string
+	}{
+		{name: "combined", strategy: TLSStrategyCombined, want: [][]string{hosts}},
+		{name: "per host", strategy: TLSStrategyPerHost, want: [][]string{{"a.example.com"}, {"b.example.com"}, {"c.example.com"}}},
+		{name: "per tls", strategy: TLSStrategyPerTLS, origin: origin, want: [][]string{{"a.example.com", "b.example.com"}, {"c.example.com"}}},
+		{name: "per tls without tls entries", strategy: TLSStrategyPerTLS, want: [][]string{{"a.example.com"}, {"b.example.com"}, {"c.example.com"}}},
+		{name: "combined without covered hosts", strategy: TLSStrategyCombined, covered: []string{"a.example.com"}, want: [][]string{{"b.example.com", "c.example.com"}}},
+		{name: "per tls without covered hosts", strategy: TLSStrategyPerTLS, origin: origin, covered: []string{"a.example.com", "b.example.com"}, want: [][]string{{"c.example.com"}}},
+		{name: "all hosts covered", strategy: TLSStrategyPerHost, covered: hosts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{TLSStrategy: tt.strategy}, nil, nil)
+			groups := i.tlsGroups("id-shop", hosts, tt.origin, tt.covered)
+
+			var got [][]string
+			secrets := map[string]bool{}
+			for _, group := range groups {
+				got = append(got, group.Hosts)
+				if group.SecretName == "" || secrets[group.SecretName] {
+					t.Errorf("secret name %q of group %v is empty or not unique", group.SecretName, group.Hosts)
+				}
+				secrets[group.SecretName] = true
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tlsGroups() hosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		host    string
+		want    bool
+	}{
+		{name: "no restriction", host: "shop.example.com", want: true},
+		{name: "domain", domains: []string{"example.com"}, host: "example.com", want: true},
+		{name: "subdomain", domains: []string{"example.com"}, host: "shop.example.com", want: true},
+		{name: "wildcard host", domains: []string{"example.com"}, host: "*.example.com", want: true},
+		{name: "wildcard domain", domains: []string{"*.example.com"}, host: "shop.example.com", want: true},
+		{name: "case insensitive", domains: []string{"Example.com"}, host: "SHOP.example.com", want: true},
+		{name: "other domain", domains: []string{"example.com"}, host: "example.org", want: false},
+		{name: "suffix without dot", domains: []string{"example.com"}, host: "badexample.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{AllowedDomains: tt.domains}, nil, nil)
+			if got := i.hostAllowed(tt.host); got != tt.want {
+				t.Errorf("hostAllowed(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		ingresses []corev1.LoadBalancerIngress
+		ips       []string
+		hostnames []string
+	}{
+		{name: "empty"},
+		{
+			name:      "sorted ips",
+			ingresses: []corev1.LoadBalancerIngress{{IP: "10.0.0.2"}, {IP: "2001:db8::1"}, {IP: "10.0.0.1"}},
+			ips:       []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"},
+		},
+		{
+			name:      "sorted hostnames",
+			ingresses: []corev1.LoadBalancerIngress{{Hostname: "b.elb.example.com"}, {Hostname: "a.elb.example.com"}},
+			hostnames: []string{"a.elb.example.com", "b.elb.example.com"},
+		},
+		{
+			name:      "mixed",
+			ingresses: []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}, {IP: "10.0.0.1"}},
+			ips:       []string{"10.0.0.1"},
+			hostnames: []string{"lb.example.com"},
+		},
+		{
+			name:      "ip takes precedence within an entry",
+			ingresses: []corev1.LoadBalancerIngress{{IP: "10.0.0.1", Hostname: "lb.example.com"}},
+			ips:       []string{"10.0.0.1"},
+		},
+		{
+			name:      "empty entries are skipped",
+			ingresses: []corev1.LoadBalancerIngress{{}, {IP: "10.0.0.1"}},
+			ips:       []string{"10.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, hostnames := loadBalancerAddresses(tt.ingresses)
+			if strings.Join(ips, ",") != strings.Join(tt.ips, ",") {
+				t.Errorf("ips = %v, want %v", ips, tt.ips)
+			}
+			if strings.Join(hostnames, ",") != strings.Join(tt.hostnames, ",") {
+				t.Errorf("hostnames = %v, want %v", hostnames, tt.hostnames)
+			}
+		})
+	}
+}
+
+func TestIPFamilyOf(t *testing.T) {
+	tests := []struct {
+		address string
+		want    corev1.IPFamily
+	}{
+		{address: "10.0.0.1", want: corev1.IPv4Protocol},
+		{address: "2001:db8::1", want: corev1.IPv6Protocol},
+		{address: "::ffff:10.0.0.1", want: corev1.IPv4Protocol},
+		{address: "lb.example.com", want: ""},
+		{address: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := ipFamilyOf(tt.address); got != tt.want {
+			t.Errorf("ipFamilyOf(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestResolveServiceEndpointsIPFamilies(t *testing.T) {
+	tests := []struct {
+		name     string
+		families []corev1.IPFamily
+		want     []corev1.IPFamily
+		policy   corev1.IPFamilyPolicy
+	}{
+		{name: "dual stack", families: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}, want: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}, policy: corev1.IPFamilyPolicyPreferDualStack},
+		{name: "ipv6 first", families: []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol}, want: []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol}, policy: corev1.IPFamilyPolicyPreferDualStack},
+		{name: "ipv6 only", families: []corev1.IPFamily{corev1.IPv6Protocol}, want: []corev1.IPFamily{corev1.IPv6Protocol}, policy: corev1.IPFamilyPolicySingleStack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := testController(PropagationControllerOptions{TargetIPFamilies: tt.families}, []client.Object{
+				testService("web", 80, corev1.LoadBalancerIngress{IP: "10.0.0.1"}, corev1.LoadBalancerIngress{IP: "2001:db8::1"}),
+			}, nil)
+			prop, err := i.FromIngressToPropagation(context.Background(), i.Client, testIngress("shop", "shop.example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			service := prop.Services[0]
+			if len(service.Spec.IPFamilies) != len(tt.want) || service.Spec.IPFamilies[0] != tt.want[0] {
+				t.Errorf("families = %v, want %v", service.Spec.IPFamilies, tt.want)
+			}
+			if *service.Spec.IPFamilyPolicy != tt.policy {
+				t.Errorf("policy = %s, want %s", *service.Spec.IPFamilyPolicy, tt.policy)
+			}
+			if subsets := len(prop.Endpoints[0].Subsets); subsets != len(tt.want) {
+				t.Errorf("subsets = %d, want one per family", subsets)
+			}
+		})
+	}
+}
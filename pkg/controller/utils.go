@@ -1,14 +1,66 @@
 package controller
 
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
 const WellKnownIngressAnnotation = "kubernetes.io/ingress.class"
 const MetaBase = "ingress-propagator.buttah.cloud"
 
 var LabelManaged = MetaBase + "/managed-by"
 var LabelPropagator = MetaBase + "/propagator"
 
+// AnnotationOrigin references the origin ingress (namespace/name) on target objects
+var AnnotationOrigin = MetaBase + "/origin"
+
+// AnnotationCertificateSecretName overrides the name of the secret synced back from the target
+var AnnotationCertificateSecretName = MetaBase + "/certificate-secret-name"
+
 const IssuerNamespacedAnnotation = "cert-manager.io/issuer"
 const IssuerClusterAnnotation = "cert-manager.io/cluster-issuer"
 
+// Certificate modes on the target cluster
+const (
+	// Issuer annotations on the ingress, certificates are created by cert-manager ingress-shim
+	CertificateModeAnnotation = "annotation"
+	// Explicit cert-manager certificate resources
+	CertificateModeResource = "certificate"
+)
+
+// TLS strategies for certificates issued on the target cluster
+const (
+	// One certificate for all hosts of an ingress
+	TLSStrategyCombined = "combined"
+	// One certificate per host
+	TLSStrategyPerHost = "per-host"
+	// One certificate per tls entry of the origin ingress
+	TLSStrategyPerTLS = "per-tls"
+)
+
+// Admission webhook modes for ingresses that can't be propagated
+const (
+	// Reject the ingress
+	WebhookModeDeny = "deny"
+	// Admit the ingress with a warning
+	WebhookModeWarn = "warn"
+)
+
+// AnnotationDeletionPolicy overrides the deletion policy of an ingress
+var AnnotationDeletionPolicy = MetaBase + "/deletion-policy"
+
+// AnnotationRetained marks target objects retained after their origin was deleted, they aren't pruned
+var AnnotationRetained = MetaBase + "/retained"
+
+// Deletion policies of target objects once the origin ingress is deleted
+const (
+	// Delete the target objects
+	DeletionPolicyDelete = "Delete"
+	// Strip the managed labels, the objects are left to the target cluster
+	DeletionPolicyOrphan = "Orphan"
+	// Keep the objects managed, but don't touch them until the origin ingress is created again
+	DeletionPolicyRetain = "Retain"
+)
+
+var CertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
 func stringSliceContains(slice []string, element string) bool {
 	for _, sliceElement := range slice {
 		if sliceElement == element {
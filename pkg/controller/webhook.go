@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// IngressValidator validates ingresses of the controlled classes at admission. It runs the same checks
+// as the propagation without writing anything to the target cluster.
+type IngressValidator struct {
+	Controller *PropagationController
+	// Deny or warn about ingresses that can't be propagated
+	Mode string
+}
+
+func (v *IngressValidator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&networkingv1.Ingress{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *IngressValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, obj)
+}
+
+func (v *IngressValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, newObj)
+}
+
+func (v *IngressValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *IngressValidator) validate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	ingress, ok := obj.(*networkingv1.Ingress)
+	if !ok {
+		return nil, fmt.Errorf("expected an ingress but got %T", obj)
+	}
+	// Ingresses being deleted are not propagated anymore
+	if !ingress.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	controlled, err := v.Controller.isControlledByThisController(ctx, *ingress)
+	if err != nil {
+		return nil, fmt.Errorf("check if ingress is controlled by this controller: %s", err)
+	}
+	if !controlled {
+		return nil, nil
+	}
+
+	_, err = v.Controller.FromIngressToPropagation(ctx, v.Controller.Client, *ingress)
+	if err != nil {
+		// Transient errors, eg. a backend without address yet, don't deny the ingress
+		if _, permanent := IsPermanent(err); v.Mode == WebhookModeWarn || !permanent {
+			return admission.Warnings{fmt.Sprintf("ingress can't be propagated: %s", err)}, nil
+		}
+		return nil, fmt.Errorf("ingress can't be propagated: %s", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,180 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is a pending change of an object on the target cluster
+type Change struct {
+	Action string
+	Kind   string
+	Name   string
+	// Differing fields of updates
+	Fields []string
+}
+
+func (c Change) String() string {
+	if len(c.Fields) == 0 {
+		return fmt.Sprintf("%s %s %s", c.Action, c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s %s %s (%s)", c.Action, c.Kind, c.Name, strings.Join(c.Fields, ", "))
+}
+
+// Count returns the number of changes by action
+func Count(changes []Change) map[string]int {
+	counts := map[string]int{ActionCreate: 0, ActionUpdate: 0, ActionDelete: 0}
+	for _, change := range changes {
+		counts[change.Action]++
+	}
+	return counts
+}
+
+// Compare returns the fields of the desired object which differ from the current one. Of the metadata
+// only labels and annotations are compared, they are replaced as a whole so keys missing on the desired
+// object differ as well. Other fields not set on the desired object (including zero values) are ignored,
+// as they are defaulted or maintained by the API server.
+func Compare(current runtime.Object, desired runtime.Object) ([]string, error) {
+	c, err := toMap(current)
+	if err != nil {
+		return nil, err
+	}
+	d, err := toMap(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	compare("", c, d, &fields)
+	return fields, nil
+}
+
+func toMap(obj runtime.Object) (map[string]interface{}, error) {
+	// Unstructured objects are converted without copy
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj.DeepCopyObject())
+	if err != nil {
+		return nil, fmt.Errorf("convert %T: %s", obj, err)
+	}
+
+	metadata, _ := m["metadata"].(map[string]interface{})
+	m["metadata"] = map[string]interface{}{
+		"labels":      metadata["labels"],
+		"annotations": metadata["annotations"],
+	}
+	delete(m, "status")
+	delete(m, "apiVersion")
+	delete(m, "kind")
+	return m, nil
+}
+
+func compare(path string, current interface{}, desired interface{}, fields *[]string) {
+	if path == "metadata.labels" || path == "metadata.annotations" {
+		compareMaps(path, current, desired, fields)
+		return
+	}
+	if isZero(desired) {
+		return
+	}
+
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		c, ok := current.(map[string]interface{})
+		if !ok {
+			*fields = append(*fields, field(path, current, desired))
+			return
+		}
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			compare(join(path, key), c[key], d[key], fields)
+		}
+	case []interface{}:
+		c, ok := current.([]interface{})
+		if !ok || len(c) != len(d) {
+			*fields = append(*fields, field(path, current, desired))
+			return
+		}
+		for index := range d {
+			compare(fmt.Sprintf("%s[%d]", path, index), c[index], d[index], fields)
+		}
+	default:
+		if !reflect.DeepEqual(current, desired) {
+			*fields = append(*fields, field(path, current, desired))
+		}
+	}
+}
+
+// compareMaps compares the keys of both maps, keys missing on either side differ
+func compareMaps(path string, current interface{}, desired interface{}, fields *[]string) {
+	c, _ := current.(map[string]interface{})
+	d, _ := desired.(map[string]interface{})
+	keys := make([]string, 0, len(c)+len(d))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	for key := range d {
+		if _, ok := c[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !reflect.DeepEqual(c[key], d[key]) {
+			*fields = append(*fields, field(join(path, key), c[key], d[key]))
+		}
+	}
+}
+
+func isZero(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return reflect.ValueOf(value).IsZero()
+}
+
+// field formats a differing field, secret data is never printed
+func field(path string, current interface{}, desired interface{}) string {
+	if path == "data" || strings.HasPrefix(path, "data.") || path == "stringData" || strings.HasPrefix(path, "stringData.") {
+		return path
+	}
+	return fmt.Sprintf("%s: %s -> %s", path, format(current), format(desired))
+}
+
+func format(value interface{}) string {
+	if value == nil {
+		return "<unset>"
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(b)
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,65 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompare(t *testing.T) {
+	service := func(labels map[string]string, annotations map[string]string, externalName string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "id-shop-web", Namespace: "target", Labels: labels, Annotations: annotations, ResourceVersion: "7"},
+			Spec:       corev1.ServiceSpec{ExternalName: externalName},
+		}
+	}
+	managed := map[string]string{"managed": "id"}
+	tests := []struct {
+		name    string
+		current *corev1.Service
+		desired *corev1.Service
+		want    []string
+	}{
+		{name: "equal", current: service(managed, nil, "web.example.com"), desired: service(managed, nil, "web.example.com")},
+		{name: "changed field", current: service(managed, nil, "old.example.com"), desired: service(managed, nil, "web.example.com"),
+			want: []string{`spec.externalName: "old.example.com" -> "web.example.com"`}},
+		{name: "field defaulted by server", current: func() *corev1.Service {
+			s := service(managed, nil, "")
+			s.Spec.ClusterIP = "10.96.0.10"
+			return s
+		}(), desired: service(managed, nil, "")},
+		{name: "label added", current: service(managed, nil, ""), desired: service(map[string]string{"managed": "id", "team": "shop"}, nil, ""),
+			want: []string{`metadata.labels.team: <unset> -> "shop"`}},
+		{name: "label removed", current: service(map[string]string{"managed": "id", "team": "shop"}, nil, ""), desired: service(managed, nil, ""),
+			want: []string{`metadata.labels.team: "shop" -> <unset>`}},
+		{name: "all annotations removed", current: service(managed, map[string]string{"note": "x"}, ""), desired: service(managed, nil, ""),
+			want: []string{`metadata.annotations.note: "x" -> <unset>`}},
+		{name: "empty label value", current: service(map[string]string{"managed": "id", "team": "shop"}, nil, ""), desired: service(map[string]string{"managed": "id", "team": ""}, nil, ""),
+			want: []string{`metadata.labels.team: "shop" -> ""`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compare(tt.current, tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareHidesSecretData(t *testing.T) {
+	current := &corev1.Secret{Data: map[string][]byte{"tls.key": []byte("old")}}
+	desired := &corev1.Secret{Data: map[string][]byte{"tls.key": []byte("new")}}
+	got, err := Compare(current, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"data.tls.key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() = %q, want %q", got, want)
+	}
+}
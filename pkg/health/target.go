@@ -0,0 +1,152 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	targetReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "svc_ingress_propagator_target_reachable",
+		Help: "Whether the target cluster API is reachable with the configured credentials (1 reachable, 0 unreachable)",
+	}, []string{"identifier", "target"})
+	targetTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "svc_ingress_propagator_target_health_transitions_total",
+		Help: "Number of health state changes of the target cluster API",
+	}, []string{"identifier", "target", "state"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(targetReachable, targetTransitions)
+}
+
+type Options struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Consecutive failed checks until the target is unreachable, a single success makes it reachable again
+	FailureThreshold int
+}
+
+// TargetChecker periodically checks the target cluster API by listing ingresses of the target namespace, which
+// requires a valid token as well. The readiness check only reports the cached result.
+type TargetChecker struct {
+	Options    Options
+	Client     client.Client
+	Namespace  string
+	Identifier string
+	// Host of the target cluster
+	Target   string
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// Object transition events are recorded on
+	EventObject runtime.Object
+
+	mu       sync.RWMutex
+	checked  bool
+	healthy  bool
+	failures int
+	since    time.Time
+	err      error
+}
+
+// Start checks the target until the context is done, it runs on all replicas regardless of leader election
+func (c *TargetChecker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Options.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.record(c.check(ctx))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection is false, readiness of standby replicas reflects the target as well
+func (c *TargetChecker) NeedLeaderElection() bool {
+	return false
+}
+
+// Check is a healthz.Checker failing while the target is unreachable
+func (c *TargetChecker) Check(_ *http.Request) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.checked {
+		return fmt.Errorf("target %s not checked yet", c.Target)
+	}
+	if !c.healthy {
+		return fmt.Errorf("target %s unreachable since %s: %s", c.Target, c.since.Format(time.RFC3339), c.err)
+	}
+	return nil
+}
+
+func (c *TargetChecker) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Options.Timeout)
+	defer cancel()
+	return c.Client.List(ctx, &networkingv1.IngressList{}, client.InNamespace(c.Namespace), client.Limit(1))
+}
+
+func (c *TargetChecker) record(result error) {
+	c.mu.Lock()
+	first := !c.checked
+	changed := false
+	if result == nil {
+		c.failures = 0
+		changed = first || !c.healthy
+		c.healthy = true
+	} else {
+		c.failures++
+		c.err = result
+		// Without previous result the target is unreachable right away
+		if first || (c.healthy && c.failures >= c.Options.FailureThreshold) {
+			changed = true
+			c.healthy = false
+		}
+	}
+	if changed {
+		c.since = time.Now()
+	}
+	c.checked = true
+	healthy, failures := c.healthy, c.failures
+	c.mu.Unlock()
+
+	if healthy {
+		targetReachable.WithLabelValues(c.Identifier, c.Target).Set(1)
+	} else {
+		targetReachable.WithLabelValues(c.Identifier, c.Target).Set(0)
+	}
+	if !changed {
+		if result != nil {
+			c.Log.V(1).Info("target check failed", "target", c.Target, "failures", failures, "error", result.Error())
+		}
+		return
+	}
+
+	if healthy {
+		c.Log.Info("target became reachable", "target", c.Target)
+		// The first successful check is no transition
+		if !first {
+			targetTransitions.WithLabelValues(c.Identifier, c.Target, "reachable").Inc()
+			c.Recorder.Eventf(c.EventObject, corev1.EventTypeNormal, "TargetReachable", "Target cluster %s is reachable", c.Target)
+		}
+		return
+	}
+	targetTransitions.WithLabelValues(c.Identifier, c.Target, "unreachable").Inc()
+	c.Log.Info("target became unreachable", "target", c.Target, "error", result.Error())
+	c.Recorder.Eventf(c.EventObject, corev1.EventTypeWarning, "TargetUnreachable", "Target cluster %s is unreachable: %s", c.Target, result)
+}
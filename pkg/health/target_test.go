@@ -0,0 +1,72 @@
+package health
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecord(t *testing.T) {
+	failed := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		results     []error
+		healthy     bool
+		events      int
+		reachable   float64
+		unreachable float64
+	}{
+		{name: "first success", results: []error{nil}, healthy: true},
+		{name: "first failure", results: []error{failed}, healthy: false, events: 1, unreachable: 1},
+		{name: "failures below threshold", results: []error{nil, failed, failed}, healthy: true},
+		{name: "failures reach threshold", results: []error{nil, failed, failed, failed}, healthy: false, events: 1, unreachable: 1},
+		{name: "success resets failures", results: []error{nil, failed, failed, nil, failed, failed}, healthy: true},
+		{name: "recovers with a single success", results: []error{nil, failed, failed, failed, nil}, healthy: true, events: 2, reachable: 1, unreachable: 1},
+		{name: "further failures are no transition", results: []error{failed, failed, failed, failed}, healthy: false, events: 1, unreachable: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(100)
+			// Each case has its own target, metrics are global
+			c := &TargetChecker{
+				Options:     Options{FailureThreshold: 3},
+				Identifier:  "id",
+				Target:      tt.name,
+				Log:         logr.Discard(),
+				Recorder:    recorder,
+				EventObject: &networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: "propagator"}},
+			}
+			if err := c.Check(nil); err == nil {
+				t.Errorf("Check() passed before the first check")
+			}
+
+			for _, result := range tt.results {
+				c.record(result)
+			}
+			if err := c.Check(nil); (err == nil) != tt.healthy {
+				t.Errorf("Check() error = %v, want healthy %v", err, tt.healthy)
+			}
+			if got := len(recorder.Events); got != tt.events {
+				t.Errorf("events = %d, want %d", got, tt.events)
+			}
+
+			want := 0.0
+			if tt.healthy {
+				want = 1
+			}
+			if got := testutil.ToFloat64(targetReachable.WithLabelValues("id", tt.name)); got != want {
+				t.Errorf("reachable = %v, want %v", got, want)
+			}
+			for state, want := range map[string]float64{"reachable": tt.reachable, "unreachable": tt.unreachable} {
+				if got := testutil.ToFloat64(targetTransitions.WithLabelValues("id", tt.name, state)); got != want {
+					t.Errorf("%s transitions = %v, want %v", state, got, want)
+				}
+			}
+		})
+	}
+}
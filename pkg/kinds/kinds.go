@@ -0,0 +1,24 @@
+package kinds
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Unknown is the kind of objects not registered in the scheme
+const Unknown = "unknown"
+
+// Of returns the kind of an object, lists return the kind of their items
+func Of(scheme *runtime.Scheme, obj runtime.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return Unknown
+	}
+	if meta.IsListType(obj) {
+		return strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return gvk.Kind
+}
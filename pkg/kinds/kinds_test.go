@@ -0,0 +1,38 @@
+package kinds
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+type unregistered struct {
+	corev1.Secret
+}
+
+func TestOf(t *testing.T) {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"})
+	tests := []struct {
+		name string
+		obj  runtime.Object
+		want string
+	}{
+		{name: "object", obj: &networkingv1.Ingress{}, want: "Ingress"},
+		{name: "list", obj: &corev1.ServiceList{}, want: "Service"},
+		{name: "unstructured", obj: certificate, want: "Certificate"},
+		{name: "unregistered", obj: &unregistered{}, want: Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Of(scheme.Scheme, tt.obj); got != tt.want {
+				t.Errorf("Of() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// Log formats
+const (
+	FormatText    = "text"
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Keys shared by all controller logs
+const (
+	KeyIngress    = "ingress"
+	KeyNamespace  = "namespace"
+	KeyIdentifier = "identifier"
+	KeyTarget     = "target"
+)
+
+type Options struct {
+	// Output format (text, json or console)
+	Format string
+	// Numeric verbosity, higher values log more
+	Level int
+	// Sample repeated messages, the first 100 per second are logged and every 100th thereafter
+	Sampling bool
+}
+
+// New returns the root logger, text uses the standard library logger while json and console are zap based
+func New(options Options) (logr.Logger, error) {
+	if options.Level < 0 {
+		return logr.Discard(), fmt.Errorf("log level must not be negative")
+	}
+
+	var encoder zapcore.Encoder
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = zapcore.RFC3339TimeEncoder
+	switch options.Format {
+	case FormatText:
+		stdr.SetVerbosity(options.Level)
+		return stdr.NewWithOptions(log.New(os.Stderr, "", log.LstdFlags), stdr.Options{LogCaller: stdr.All}), nil
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(config)
+	case FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(config)
+	default:
+		return logr.Discard(), fmt.Errorf("unknown log format %s", options.Format)
+	}
+
+	// logr verbosity maps to negative zap levels
+	sink := zapcore.Lock(os.Stderr)
+	core := zapcore.NewCore(&crzap.KubeAwareEncoder{Encoder: encoder}, sink, zap.NewAtomicLevelAt(zapcore.Level(-options.Level)))
+	if options.Sampling {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
+	}
+
+	return zapr.NewLogger(zap.New(core, zap.ErrorOutput(sink), zap.AddStacktrace(zapcore.ErrorLevel))), nil
+}
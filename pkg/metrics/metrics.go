@@ -0,0 +1,314 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/kinds"
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "svc_ingress_propagator"
+
+// Propagation states
+const (
+	StateSynced   = "synced"
+	StateReady    = "ready"
+	StatePending  = "pending"
+	StateDegraded = "degraded"
+	StateConflict = "conflict"
+	StateFailed   = "failed"
+)
+
+var states = []string{StateSynced, StateReady, StatePending, StateDegraded, StateConflict, StateFailed}
+
+var (
+	propagations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "propagations",
+		Help:      "Number of propagations by state",
+	}, []string{"identifier", "target", "state"})
+	propagatedServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "propagated_services",
+		Help:      "Number of services propagated to the target cluster",
+	}, []string{"identifier", "target"})
+	propagatedEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "propagated_endpoints",
+		Help:      "Number of endpoints propagated to the target cluster",
+	}, []string{"identifier", "target"})
+	backendsWithoutAddress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backends_without_loadbalancer_address",
+		Help:      "Number of backend services without loadbalancer address",
+	}, []string{"identifier", "target"})
+	conflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conflicts_total",
+		Help:      "Number of conflicts detected with objects on the target cluster",
+	}, []string{"identifier", "target"})
+	syncLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_latency_seconds",
+		Help:      "Time from observing a change of an origin ingress until it is synced to the target cluster",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"identifier", "target"})
+	targetRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "target_request_duration_seconds",
+		Help:      "Latency of requests to the target cluster API",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"identifier", "target", "verb", "kind"})
+	dryRunChanges = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dry_run_changes",
+		Help:      "Number of target changes not applied in dry run mode by action",
+	}, []string{"identifier", "target", "action"})
+	targetRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "target_request_errors_total",
+		Help:      "Number of failed requests to the target cluster API, objects not found are expected and not counted",
+	}, []string{"identifier", "target", "verb", "kind", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		propagations,
+		propagatedServices,
+		propagatedEndpoints,
+		backendsWithoutAddress,
+		conflicts,
+		syncLatency,
+		targetRequestDuration,
+		targetRequestErrors,
+		dryRunChanges,
+	)
+}
+
+// propagationState is the last recorded state of an origin ingress
+type propagationState struct {
+	state                  string
+	services               int
+	endpoints              int
+	backendsWithoutAddress int
+	generation             int64
+	observed               time.Time
+	synced                 bool
+	dryRunChanges          map[string]int
+}
+
+// Recorder records the metrics of a propagator syncing to a target. All methods are safe
+// to use on a nil recorder.
+type Recorder struct {
+	identifier string
+	target     string
+
+	mu     sync.Mutex
+	origin map[types.NamespacedName]*propagationState
+	// Actions of dry run changes recorded so far
+	actions map[string]bool
+}
+
+func NewRecorder(identifier string, target string) *Recorder {
+	return &Recorder{
+		identifier: identifier,
+		target:     target,
+		origin:     map[types.NamespacedName]*propagationState{},
+		actions:    map[string]bool{},
+	}
+}
+
+// Observe records that a generation of an origin ingress was observed, the sync latency is measured from the first observation
+func (r *Recorder) Observe(origin types.NamespacedName, generation int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(origin)
+	if s.generation != generation {
+		s.generation = generation
+		s.observed = time.Now()
+		s.synced = false
+	}
+}
+
+// Synced records a successful sync of an origin ingress with its number of propagated objects
+func (r *Recorder) Synced(origin types.NamespacedName, services int, endpoints int, withoutAddress int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(origin)
+	s.services = services
+	s.endpoints = endpoints
+	s.backendsWithoutAddress = withoutAddress
+	if !s.synced && !s.observed.IsZero() {
+		syncLatency.WithLabelValues(r.identifier, r.target).Observe(time.Since(s.observed).Seconds())
+		s.synced = true
+	}
+	r.update()
+}
+
+// SetState records the state of an origin ingress
+func (r *Recorder) SetState(origin types.NamespacedName, state string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(origin).state = state
+	r.update()
+}
+
+// SetBackendsWithoutAddress records the number of backends of an origin ingress without loadbalancer address
+func (r *Recorder) SetBackendsWithoutAddress(origin types.NamespacedName, count int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(origin).backendsWithoutAddress = count
+	r.update()
+}
+
+// SetDryRunChanges records the number of target changes by action of an origin ingress not applied in dry run mode
+func (r *Recorder) SetDryRunChanges(origin types.NamespacedName, changes map[string]int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(origin).dryRunChanges = changes
+	for action := range changes {
+		r.actions[action] = true
+	}
+	r.update()
+}
+
+// Conflict records a conflict of an origin ingress
+func (r *Recorder) Conflict(origin types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	conflicts.WithLabelValues(r.identifier, r.target).Inc()
+	r.SetState(origin, StateConflict)
+}
+
+// Forget removes an origin ingress from all metrics
+func (r *Recorder) Forget(origin types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.origin, origin)
+	r.update()
+}
+
+// get returns the state of an origin, expects the lock to be held
+func (r *Recorder) get(origin types.NamespacedName) *propagationState {
+	s, ok := r.origin[origin]
+	if !ok {
+		s = &propagationState{}
+		r.origin[origin] = s
+	}
+	return s
+}
+
+// update recalculates all gauges, expects the lock to be held
+func (r *Recorder) update() {
+	counts := map[string]int{}
+	changes := map[string]int{}
+	services, endpoints, withoutAddress := 0, 0, 0
+	for _, s := range r.origin {
+		for action, count := range s.dryRunChanges {
+			changes[action] += count
+		}
+		if s.state != "" {
+			counts[s.state]++
+		}
+		services += s.services
+		endpoints += s.endpoints
+		withoutAddress += s.backendsWithoutAddress
+	}
+
+	for _, state := range states {
+		propagations.WithLabelValues(r.identifier, r.target, state).Set(float64(counts[state]))
+	}
+	propagatedServices.WithLabelValues(r.identifier, r.target).Set(float64(services))
+	propagatedEndpoints.WithLabelValues(r.identifier, r.target).Set(float64(endpoints))
+	backendsWithoutAddress.WithLabelValues(r.identifier, r.target).Set(float64(withoutAddress))
+	for action := range r.actions {
+		dryRunChanges.WithLabelValues(r.identifier, r.target, action).Set(float64(changes[action]))
+	}
+}
+
+// InstrumentClient wraps a client of the target cluster, recording latency and errors of all requests
+func (r *Recorder) InstrumentClient(c client.WithWatch) client.WithWatch {
+	observe := func(verb string, obj client.Object, start time.Time, err error) {
+		kind := kinds.Of(c.Scheme(), obj)
+		targetRequestDuration.WithLabelValues(r.identifier, r.target, verb, kind).Observe(time.Since(start).Seconds())
+		// Objects are updated before they are created and looked up or deleted whether they exist or not, only a
+		// missing namespace on create is a failure
+		if err != nil && (verb == "create" || !k8serrors.IsNotFound(err)) {
+			targetRequestErrors.WithLabelValues(r.identifier, r.target, verb, kind, string(k8serrors.ReasonForError(err))).Inc()
+		}
+	}
+
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			start := time.Now()
+			err := c.Get(ctx, key, obj, opts...)
+			observe("get", obj, start, err)
+			return err
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			start := time.Now()
+			err := c.List(ctx, list, opts...)
+			targetRequestDuration.WithLabelValues(r.identifier, r.target, "list", kinds.Of(c.Scheme(), list)).Observe(time.Since(start).Seconds())
+			if err != nil {
+				targetRequestErrors.WithLabelValues(r.identifier, r.target, "list", kinds.Of(c.Scheme(), list), string(k8serrors.ReasonForError(err))).Inc()
+			}
+			return err
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			start := time.Now()
+			err := c.Create(ctx, obj, opts...)
+			observe("create", obj, start, err)
+			return err
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			start := time.Now()
+			err := c.Update(ctx, obj, opts...)
+			observe("update", obj, start, err)
+			return err
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			start := time.Now()
+			err := c.Patch(ctx, obj, patch, opts...)
+			observe("patch", obj, start, err)
+			return err
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			start := time.Now()
+			err := c.Delete(ctx, obj, opts...)
+			observe("delete", obj, start, err)
+			return err
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInstrumentClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func(ctx context.Context, c client.Client) error
+		verb    string
+		kind    string
+		reason  string
+		errors  float64
+	}{
+		{
+			name: "update before create",
+			request: func(ctx context.Context, c client.Client) error {
+				return c.Update(ctx, &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "target"}})
+			},
+			verb: "update", kind: "Ingress", reason: "NotFound",
+		},
+		{
+			name: "lookup",
+			request: func(ctx context.Context, c client.Client) error {
+				return c.Get(ctx, client.ObjectKey{Namespace: "target", Name: "web"}, &corev1.Service{})
+			},
+			verb: "get", kind: "Service", reason: "NotFound",
+		},
+		{
+			name: "delete of a removed object",
+			request: func(ctx context.Context, c client.Client) error {
+				return c.Delete(ctx, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "target"}})
+			},
+			verb: "delete", kind: "Service", reason: "NotFound",
+		},
+		{
+			name: "create of an existing object",
+			request: func(ctx context.Context, c client.Client) error {
+				return c.Create(ctx, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "target"}})
+			},
+			verb: "create", kind: "Service", reason: "AlreadyExists", errors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each case has its own target, metrics are global
+			r := NewRecorder("id", tt.name)
+			c := r.InstrumentClient(fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "target"}},
+			).Build())
+
+			if err := tt.request(context.Background(), c); err == nil {
+				t.Fatal("request succeeded, want an error")
+			}
+			if got := testutil.ToFloat64(targetRequestErrors.WithLabelValues("id", tt.name, tt.verb, tt.kind, tt.reason)); got != tt.errors {
+				t.Errorf("errors = %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/logging"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	ProbeTCP   = "tcp"
+	ProbeHTTP  = "http"
+	ProbeHTTPS = "https"
+)
+
+var (
+	backendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "svc_ingress_propagator_backend_healthy",
+		Help: "Health of a propagated backend address (1 healthy, 0 unhealthy)",
+	}, []string{"identifier", "target", "address", "port"})
+	backendTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "svc_ingress_propagator_backend_health_transitions_total",
+		Help: "Number of health state changes of a propagated backend address",
+	}, []string{"identifier", "target", "address", "port", "state"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(backendHealthy, backendTransitions)
+}
+
+type Options struct {
+	// Probe type (tcp, http, https)
+	Type string
+	// Request path for http and https probes
+	Path             string
+	Interval         time.Duration
+	Timeout          time.Duration
+	SuccessThreshold int
+	FailureThreshold int
+}
+
+// Target is a propagated backend address
+type Target struct {
+	Address string
+	Port    int32
+}
+
+func (t Target) String() string {
+	return net.JoinHostPort(t.Address, strconv.Itoa(int(t.Port)))
+}
+
+type state struct {
+	healthy   bool
+	successes int
+	failures  int
+}
+
+// Prober periodically probes all registered targets. Health changes trigger a reconcile
+// of the ingresses using the target.
+type Prober struct {
+	Options    Options
+	Identifier string
+	// Host of the target cluster
+	Target   string
+	Log      logr.Logger
+	Recorder record.EventRecorder
+
+	mu      sync.RWMutex
+	states  map[Target]*state
+	origins map[types.NamespacedName]networkingv1.Ingress
+	targets map[types.NamespacedName][]Target
+	events  chan event.GenericEvent
+}
+
+func New(options Options, identifier string, target string, log logr.Logger, recorder record.EventRecorder) *Prober {
+	return &Prober{
+		Options:    options,
+		Identifier: identifier,
+		Target:     target,
+		Log:        log.WithValues(logging.KeyIdentifier, identifier, logging.KeyTarget, target),
+		Recorder:   recorder,
+		states:     map[Target]*state{},
+		origins:    map[types.NamespacedName]networkingv1.Ingress{},
+		targets:    map[types.NamespacedName][]Target{},
+		events:     make(chan event.GenericEvent, 1024),
+	}
+}
+
+// Events returns the channel on which ingresses with changed backend health are sent
+func (p *Prober) Events() <-chan event.GenericEvent {
+	return p.events
+}
+
+// Register sets the targets probed for an origin ingress
+func (p *Prober) Register(origin networkingv1.Ingress, targets []Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name}
+	p.origins[key] = origin
+	p.targets[key] = targets
+	for _, target := range targets {
+		if _, ok := p.states[target]; !ok {
+			p.states[target] = &state{healthy: true}
+		}
+	}
+	p.cleanup()
+}
+
+// Unregister stops probing the targets of an origin ingress
+func (p *Prober) Unregister(origin networkingv1.Ingress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: origin.Namespace, Name: origin.Name}
+	delete(p.origins, key)
+	delete(p.targets, key)
+	p.cleanup()
+}
+
+// Healthy returns the health of a target, targets which were not probed yet are healthy
+func (p *Prober) Healthy(target Target) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if s, ok := p.states[target]; ok {
+		return s.healthy
+	}
+	return true
+}
+
+// cleanup removes the state of targets no longer used by any ingress, expects the lock to be held
+func (p *Prober) cleanup() {
+	used := map[Target]bool{}
+	for _, targets := range p.targets {
+		for _, target := range targets {
+			used[target] = true
+		}
+	}
+	for target := range p.states {
+		if !used[target] {
+			delete(p.states, target)
+			backendHealthy.DeleteLabelValues(p.Identifier, p.Target, target.Address, strconv.Itoa(int(target.Port)))
+		}
+	}
+}
+
+func (p *Prober) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.Options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.probeAll(ctx)
+		}
+	}
+}
+
+func (p *Prober) probeAll(ctx context.Context) {
+	p.mu.RLock()
+	targets := make([]Target, 0, len(p.states))
+	for target := range p.states {
+		targets = append(targets, target)
+	}
+	p.mu.RUnlock()
+
+	results := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for idx := range targets {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			results[idx] = p.probe(ctx, targets[idx])
+		}(idx)
+	}
+	wg.Wait()
+
+	for idx, target := range targets {
+		p.record(target, results[idx])
+	}
+}
+
+// record updates the state of a target and notifies the owning ingresses on changes
+func (p *Prober) record(target Target, result error) {
+	p.mu.Lock()
+	s, ok := p.states[target]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+
+	changed := false
+	if result == nil {
+		s.failures = 0
+		s.successes++
+		if !s.healthy && s.successes >= p.Options.SuccessThreshold {
+			s.healthy = true
+			changed = true
+		}
+	} else {
+		s.successes = 0
+		s.failures++
+		if s.healthy && s.failures >= p.Options.FailureThreshold {
+			s.healthy = false
+			changed = true
+		}
+	}
+	healthy := s.healthy
+
+	var origins []networkingv1.Ingress
+	if changed {
+		for key, targets := range p.targets {
+			for _, t := range targets {
+				if t == target {
+					origins = append(origins, p.origins[key])
+					break
+				}
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	port := strconv.Itoa(int(target.Port))
+	if healthy {
+		backendHealthy.WithLabelValues(p.Identifier, p.Target, target.Address, port).Set(1)
+	} else {
+		backendHealthy.WithLabelValues(p.Identifier, p.Target, target.Address, port).Set(0)
+	}
+	if !changed {
+		return
+	}
+
+	if healthy {
+		backendTransitions.WithLabelValues(p.Identifier, p.Target, target.Address, port, "healthy").Inc()
+		p.Log.Info("backend became healthy", "backend", target.String())
+	} else {
+		backendTransitions.WithLabelValues(p.Identifier, p.Target, target.Address, port, "unhealthy").Inc()
+		p.Log.Info("backend became unhealthy", "backend", target.String(), "error", result.Error())
+	}
+
+	for idx := range origins {
+		origin := origins[idx]
+		if healthy {
+			p.Recorder.Eventf(&origin, corev1.EventTypeNormal, "BackendHealthy", "Backend %s is healthy", target)
+		} else {
+			p.Recorder.Eventf(&origin, corev1.EventTypeWarning, "BackendUnhealthy", "Backend %s is unhealthy: %s", target, result)
+		}
+		// Never stall probing when the controller is behind, the ingress is reconciled with the next change
+		select {
+		case p.events <- event.GenericEvent{Object: &origin}:
+		default:
+			p.Log.V(1).Info("event queue full, dropped health change", logging.KeyIngress, origin.Name, logging.KeyNamespace, origin.Namespace)
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, target Target) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Options.Timeout)
+	defer cancel()
+
+	switch p.Options.Type {
+	case ProbeTCP:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target.String())
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP, ProbeHTTPS:
+		url := fmt.Sprintf("%s://%s%s", p.Options.Type, target, p.Options.Path)
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		client := http.Client{
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+				// Backend addresses are probed by IP, certificates can't be verified
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status code %d", response.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown probe type %s", p.Options.Type)
+	}
+}
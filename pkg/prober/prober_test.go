@@ -0,0 +1,140 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func testProber(options Options) *Prober {
+	return New(options, "id", "target.example.com", logr.Discard(), record.NewFakeRecorder(100))
+}
+
+func TestRecordThresholds(t *testing.T) {
+	failed := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		results []error
+		healthy bool
+		events  int
+	}{
+		{name: "healthy without probes", healthy: true},
+		{name: "failures below threshold", results: []error{failed, failed}, healthy: true},
+		{name: "failures reach threshold", results: []error{failed, failed, failed}, healthy: false, events: 1},
+		{name: "success resets failures", results: []error{failed, failed, nil, failed, failed}, healthy: true},
+		{name: "recovers after success threshold", results: []error{failed, failed, failed, nil, nil}, healthy: true, events: 2},
+		{name: "successes below threshold", results: []error{failed, failed, failed, nil}, healthy: false, events: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testProber(Options{SuccessThreshold: 2, FailureThreshold: 3})
+			target := Target{Address: "10.0.0.1", Port: 80}
+			p.Register(networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"}}, []Target{target})
+
+			for _, result := range tt.results {
+				p.record(target, result)
+			}
+			if got := p.Healthy(target); got != tt.healthy {
+				t.Errorf("Healthy() = %v, want %v", got, tt.healthy)
+			}
+			if got := len(p.Events()); got != tt.events {
+				t.Errorf("events = %d, want %d", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestRecordDoesNotBlock(t *testing.T) {
+	p := testProber(Options{SuccessThreshold: 1, FailureThreshold: 1})
+	p.events = make(chan event.GenericEvent)
+	target := Target{Address: "10.0.0.2", Port: 80}
+	p.Register(networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"}}, []Target{target})
+
+	done := make(chan struct{})
+	go func() {
+		p.record(target, errors.New("timeout"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("record blocked on a full event queue")
+	}
+	if p.Healthy(target) {
+		t.Errorf("target is healthy after a failure")
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	p := testProber(Options{SuccessThreshold: 1, FailureThreshold: 1})
+	target := Target{Address: "10.0.0.3", Port: 443}
+	origin := networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"}}
+	p.Register(origin, []Target{target})
+	p.record(target, errors.New("timeout"))
+
+	gauge := backendHealthy.WithLabelValues("id", "target.example.com", "10.0.0.3", "443")
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("backend healthy = %v, want 0", got)
+	}
+	transitions := backendTransitions.WithLabelValues("id", "target.example.com", "10.0.0.3", "443", "unhealthy")
+	if got := testutil.ToFloat64(transitions); got != 1 {
+		t.Errorf("unhealthy transitions = %v, want 1", got)
+	}
+
+	// Targets no longer used are forgotten
+	p.Unregister(origin)
+	if backendHealthy.DeleteLabelValues("id", "target.example.com", "10.0.0.3", "443") {
+		t.Errorf("metric of an unregistered target was not removed")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	host, portValue, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portValue)
+	target := Target{Address: host, Port: int32(port)}
+
+	// Nothing listens on the port of a closed listener
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		options Options
+		target  Target
+		wantErr bool
+	}{
+		{name: "tcp", options: Options{Type: ProbeTCP}, target: target},
+		{name: "tcp refused", options: Options{Type: ProbeTCP}, target: Target{Address: "127.0.0.1", Port: int32(closedPort)}, wantErr: true},
+		{name: "http", options: Options{Type: ProbeHTTP, Path: "/"}, target: target},
+		{name: "http unavailable", options: Options{Type: ProbeHTTP, Path: "/fail"}, target: target, wantErr: true},
+		{name: "unknown type", options: Options{Type: "udp"}, target: target, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Timeout = time.Second
+			err := testProber(tt.options).probe(context.Background(), tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package propagation
 import (
 	v1 "k8s.io/api/core/v1"                 // For Service and Endpoints
 	networkingv1 "k8s.io/api/networking/v1" // For Ingress
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // Exposure is the minimal information for exposing a service.
@@ -26,4 +27,16 @@ type Propagation struct {
 
 	// The list of endpoints associated with the propagation.
 	Endpoints []v1.Endpoints
+
+	// The list of tls secrets associated with the propagation.
+	Secrets []v1.Secret
+
+	// Names of the secrets issued by cert-manager on the target cluster.
+	IssuedSecrets []string
+
+	// The list of cert-manager certificates associated with the propagation.
+	Certificates []unstructured.Unstructured
+
+	// Names of backend services without loadbalancer address.
+	BackendsWithoutAddress []string
 }
@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/buttahtoast/svc-ingress-propagator/pkg/kinds"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const tracerName = "github.com/buttahtoast/svc-ingress-propagator"
+
+// Attribute keys shared by all spans
+const (
+	AttributeIngress         = attribute.Key("propagator.ingress")
+	AttributeIdentifier      = attribute.Key("propagator.identifier")
+	AttributeTargetNamespace = attribute.Key("propagator.target.namespace")
+	AttributeService         = attribute.Key("propagator.service")
+)
+
+type Options struct {
+	// OTLP gRPC endpoint, falls back to the OTEL_EXPORTER_OTLP_ENDPOINT environment variables
+	Endpoint string
+	Insecure bool
+	// Ratio of sampled traces (0-1)
+	SampleRatio float64
+	ServiceName string
+}
+
+// Enabled returns whether an exporter endpoint is configured by flag or environment
+func (o Options) Enabled() bool {
+	return o.Endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs the global tracer provider exporting spans via OTLP. The returned function
+// flushes and stops the exporter.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	var exporterOptions []otlptracegrpc.Option
+	if options.Endpoint != "" {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithEndpoint(options.Endpoint))
+	}
+	if options.Insecure {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithAttributes(attribute.String("service.name", options.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the global tracer, without configured provider the span is a noop
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InstrumentClient wraps a client of the target cluster, creating a span for each request
+func InstrumentClient(c client.WithWatch) client.WithWatch {
+	start := func(ctx context.Context, verb string, kind string, key client.ObjectKey) (context.Context, trace.Span) {
+		return Start(ctx, "TargetClient."+verb,
+			attribute.String("k8s.kind", kind),
+			attribute.String("k8s.name", key.Name),
+			attribute.String("k8s.namespace", key.Namespace),
+		)
+	}
+
+	return interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			ctx, span := start(ctx, "Get", kinds.Of(c.Scheme(), obj), key)
+			err := c.Get(ctx, key, obj, opts...)
+			End(span, err)
+			return err
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			listOptions := client.ListOptions{}
+			listOptions.ApplyOptions(opts)
+			ctx, span := start(ctx, "List", kinds.Of(c.Scheme(), list), client.ObjectKey{Namespace: listOptions.Namespace})
+			err := c.List(ctx, list, opts...)
+			End(span, err)
+			return err
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			ctx, span := start(ctx, "Create", kinds.Of(c.Scheme(), obj), client.ObjectKeyFromObject(obj))
+			err := c.Create(ctx, obj, opts...)
+			End(span, err)
+			return err
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			ctx, span := start(ctx, "Update", kinds.Of(c.Scheme(), obj), client.ObjectKeyFromObject(obj))
+			err := c.Update(ctx, obj, opts...)
+			End(span, err)
+			return err
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			ctx, span := start(ctx, "Patch", kinds.Of(c.Scheme(), obj), client.ObjectKeyFromObject(obj))
+			err := c.Patch(ctx, obj, patch, opts...)
+			End(span, err)
+			return err
+		},
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			ctx, span := start(ctx, "Delete", kinds.Of(c.Scheme(), obj), client.ObjectKeyFromObject(obj))
+			err := c.Delete(ctx, obj, opts...)
+			End(span, err)
+			return err
+		},
+	})
+}